@@ -7,7 +7,7 @@ import (
 	"chat-app/server/internal/application"
 	"chat-app/server/internal/infrastructure/auth"
 	"chat-app/server/internal/infrastructure/persistence/inmemory"
-	"chat-app/server/internal/infrastructure/transport/http"
+	transporthttp "chat-app/server/internal/infrastructure/transport/http"
 	"chat-app/server/internal/infrastructure/transport/websocket"
 )
 
@@ -15,22 +15,33 @@ func main() {
 	// Configuration (in a real app, this would come from a file or env vars)
 	jwtSecret := "a_very_secret_key_that_should_be_long_and_random"
 	serverAddr := ":8080"
+	internalAddr := "127.0.0.1:9090" // Metrics, reachable only from the host
 
 	// Setup Dependencies (Dependency Injection)
 	// Infrastructure Layer
 	userRepo := inmemory.NewInMemoryUserRepository()
 	groupRepo := inmemory.NewInMemoryGroupRepository()
+	mailboxRepo := inmemory.NewInMemoryMailboxRepository()
 	jwtService := auth.NewJWTService(jwtSecret, 24*time.Hour)
 
 	// Application Layer
 	chatService := application.NewChatService(userRepo, groupRepo)
+	mailboxService := application.NewMailboxService(mailboxRepo, application.DefaultMailboxConfig)
 
 	// WebSocket Hub
-	hub := websocket.NewHub(chatService)
+	hub := websocket.NewHub(chatService, jwtService, websocket.WithMailbox(mailboxService))
 	go hub.Run()
 
 	// Transport Layer (HTTP Router)
-	router := http.NewRouter(hub, jwtService, chatService)
+	router := transporthttp.NewRouter(hub, jwtService, chatService)
+	internalRouter := transporthttp.NewInternalRouter(hub)
+
+	go func() {
+		log.Printf("Internal server starting on %s", internalAddr)
+		if err := http.ListenAndServe(internalAddr, internalRouter); err != nil {
+			log.Printf("could not start internal server: %v", err)
+		}
+	}()
 
 	log.Printf("Server starting on %s", serverAddr)
 	if err := http.ListenAndServe(serverAddr, router); err != nil {
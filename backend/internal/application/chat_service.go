@@ -14,6 +14,7 @@ var (
 	ErrUserNotFound  = errors.New("user not found")
 	ErrGroupNotFound = errors.New("group not found")
 	ErrAlreadyExists = errors.New("already exists")
+	ErrDirectGroup   = errors.New("not possible in a direct conversation")
 )
 
 // ChatService handles the core application logic (use cases).
@@ -55,6 +56,15 @@ func (s *ChatService) GetUser(ctx context.Context, userID string) (*domain.User,
 }
 
 
+// GetGroup retrieves a group by its ID.
+func (s *ChatService) GetGroup(ctx context.Context, groupID string) (*domain.Group, error) {
+	group, err := s.groupRepo.GetByID(ctx, groupID)
+	if err != nil {
+		return nil, ErrGroupNotFound
+	}
+	return group, nil
+}
+
 // UnregisterUser removes a user from the system.
 func (s *ChatService) UnregisterUser(ctx context.Context, userID string) error {
 	return s.userRepo.Remove(ctx, userID)
@@ -69,7 +79,7 @@ func (s *ChatService) CreateGroup(ctx context.Context, name, joinTag, ownerID st
 	
 	// Check if join tag is unique
 	if _, err := s.groupRepo.GetByTag(ctx, joinTag); err == nil {
-		return nil, fmt.Errorf("join tag '%s' is already in use", joinTag)
+		return nil, fmt.Errorf("join tag '%s' is already in use: %w", joinTag, ErrAlreadyExists)
 	}
 
 	groupID := uuid.New().String()
@@ -89,6 +99,10 @@ func (s *ChatService) JoinGroup(ctx context.Context, groupID, userID string) (*d
 		return nil, ErrGroupNotFound
 	}
 
+	if group.Direct {
+		return nil, fmt.Errorf("cannot join: %w", ErrDirectGroup)
+	}
+
 	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
 		return nil, ErrUserNotFound
@@ -101,6 +115,49 @@ func (s *ChatService) JoinGroup(ctx context.Context, groupID, userID string) (*d
 	return group, nil
 }
 
+// OpenDirectConversation returns the direct conversation between two users,
+// creating it on first use. A user who left it is added back. added lists
+// the users who were not members before the call.
+func (s *ChatService) OpenDirectConversation(ctx context.Context, userID, peerID string) (group *domain.Group, added []string, err error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	peer, err := s.userRepo.GetByID(ctx, peerID)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+
+	groupID := domain.DirectGroupID(userID, peerID)
+	group, err = s.groupRepo.GetByID(ctx, groupID)
+	if err != nil {
+		group = domain.NewDirectGroup(userID, peerID)
+		group.AddMember(user)
+		group.AddMember(peer)
+		if err := s.groupRepo.Add(ctx, group); err == nil {
+			return group, []string{userID, peerID}, nil
+		}
+		// Someone else opened it first.
+		if group, err = s.groupRepo.GetByID(ctx, groupID); err != nil {
+			return nil, nil, fmt.Errorf("failed to create direct conversation: %w", err)
+		}
+	}
+
+	for _, member := range []*domain.User{user, peer} {
+		if !group.HasMember(member.ID) {
+			group.AddMember(member)
+			added = append(added, member.ID)
+		}
+	}
+	if len(added) == 0 {
+		return group, nil, nil
+	}
+	if err := s.groupRepo.Save(ctx, group); err != nil {
+		return nil, nil, fmt.Errorf("failed to save direct conversation: %w", err)
+	}
+	return group, added, nil
+}
+
 // LeaveGroup removes a user from a group.
 func (s *ChatService) LeaveGroup(ctx context.Context, groupID, userID string) (*domain.Group, string, error) {
 	group, err := s.groupRepo.GetByID(ctx, groupID)
@@ -124,6 +181,22 @@ func (s *ChatService) LeaveGroup(ctx context.Context, groupID, userID string) (*
 	return group, newOwnerID, nil
 }
 
+// DeleteGroupIfEmpty removes a group that has no members left.
+// It reports whether the group was removed.
+func (s *ChatService) DeleteGroupIfEmpty(ctx context.Context, groupID string) (bool, error) {
+	group, err := s.groupRepo.GetByID(ctx, groupID)
+	if err != nil {
+		return false, ErrGroupNotFound
+	}
+	if !group.IsEmpty() {
+		return false, nil
+	}
+	if err := s.groupRepo.Remove(ctx, groupID); err != nil {
+		return false, fmt.Errorf("failed to remove group: %w", err)
+	}
+	return true, nil
+}
+
 // FindGroupsByTag performs a simple search for groups by their join tag.
 func (s *ChatService) FindGroupsByTag(ctx context.Context, tagQuery string) ([]*domain.Group, error) {
 	allGroups, err := s.groupRepo.GetAll(ctx)
@@ -133,8 +206,9 @@ func (s *ChatService) FindGroupsByTag(ctx context.Context, tagQuery string) ([]*
 
 	var matchedGroups []*domain.Group
 	for _, group := range allGroups {
-		// Simple exact match for this implementation
-		if strings.EqualFold(group.JoinTag, tagQuery) {
+		// Simple exact match for this implementation. Direct conversations
+		// have no tag and are never listed.
+		if !group.Direct && strings.EqualFold(group.JoinTag, tagQuery) {
 			matchedGroups = append(matchedGroups, group)
 		}
 	}
@@ -165,3 +239,30 @@ func (s *ChatService) UpdateUserProfile(ctx context.Context, userID, displayName
     // A DB-backed repo would need a `Save` call here.
     return user, nil
 }
+
+// SetGroupMuted turns a member's notifications for a group off or back on.
+func (s *ChatService) SetGroupMuted(ctx context.Context, userID, groupID string, muted bool) (*domain.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	group, err := s.groupRepo.GetByID(ctx, groupID)
+	if err != nil {
+		return nil, ErrGroupNotFound
+	}
+	if !group.HasMember(userID) {
+		return nil, domain.ErrMemberNotFound
+	}
+	user.SetMuted(groupID, muted)
+	return user, nil
+}
+
+// UpdatePrivacy replaces a user's privacy settings.
+func (s *ChatService) UpdatePrivacy(ctx context.Context, userID string, settings domain.PrivacySettings) (*domain.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	user.SetPrivacy(settings)
+	return user, nil
+}
@@ -0,0 +1,41 @@
+package application
+
+import (
+	"context"
+	"testing"
+
+	"chat-app/server/internal/infrastructure/persistence/inmemory"
+)
+
+func TestOpenDirectConversationDistinctPairs(t *testing.T) {
+	ctx := context.Background()
+	s := NewChatService(inmemory.NewInMemoryUserRepository(), inmemory.NewInMemoryGroupRepository())
+	for _, id := range []string{"a", "a:b", "b:c", "c"} {
+		if _, err := s.RegisterUser(ctx, id, id, "key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, _, err := s.OpenDirectConversation(ctx, "a:b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, added, err := s.OpenDirectConversation(ctx, "a", "b:c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == second.ID {
+		t.Fatalf("both pairs opened %q", first.ID)
+	}
+	if len(added) != 2 || !second.HasMember("a") || !second.HasMember("b:c") || second.HasMember("c") {
+		t.Fatalf("second conversation added %v, want only a and b:c", added)
+	}
+
+	again, added, err := s.OpenDirectConversation(ctx, "c", "a:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != first.ID || len(added) != 0 {
+		t.Fatalf("reopening in the other order got %q adding %v, want %q adding nobody", again.ID, added, first.ID)
+	}
+}
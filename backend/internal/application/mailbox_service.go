@@ -0,0 +1,172 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"chat-app/server/internal/domain"
+	"github.com/google/uuid"
+)
+
+// MailboxConfig bounds what is held for an offline user. Entries older than
+// TTL are dropped, and once a mailbox holds more than MaxEntries entries or
+// MaxBytes of ciphertext the oldest entries make room for new ones. Mailboxes
+// are trimmed after every eighth of either cap deposited rather than on every
+// deposit, so they may briefly exceed a cap by that much.
+type MailboxConfig struct {
+	TTL        time.Duration
+	MaxEntries int
+	MaxBytes   int
+}
+
+// DefaultMailboxConfig holds up to a day of messages, capped at 1000
+// messages or 4 MiB of ciphertext per user.
+var DefaultMailboxConfig = MailboxConfig{TTL: 24 * time.Hour, MaxEntries: 1000, MaxBytes: 4 << 20}
+
+// MailboxService stores encrypted messages for offline users until they
+// come back and acknowledge them.
+type MailboxService struct {
+	repo   domain.MailboxRepository
+	config MailboxConfig
+	usage  map[string]*mailboxUsage // Map userID to what was deposited since the last trim
+	mu     sync.Mutex               // Guards usage
+}
+
+// mailboxUsage counts the deposits to one mailbox since it was last trimmed.
+type mailboxUsage struct {
+	entries int
+	bytes   int
+}
+
+// NewMailboxService creates a new MailboxService.
+func NewMailboxService(repo domain.MailboxRepository, config MailboxConfig) *MailboxService {
+	return &MailboxService{repo: repo, config: config, usage: make(map[string]*mailboxUsage)}
+}
+
+// TTL returns how long entries are kept.
+func (s *MailboxService) TTL() time.Duration {
+	return s.config.TTL
+}
+
+// Deposit appends a message to the user's mailbox. Expired entries and the
+// oldest ones beyond the size caps are dropped once enough has been deposited
+// since the last trim. msg describes the message; its ID, UserID and
+// ExpiresAt are filled in.
+func (s *MailboxService) Deposit(ctx context.Context, userID string, msg domain.MailboxEntry) error {
+	entry := &msg
+	entry.ID = uuid.New().String()
+	entry.UserID = userID
+	entry.ExpiresAt = msg.SentAt.Add(s.config.TTL)
+	if err := s.repo.Append(ctx, entry); err != nil {
+		return fmt.Errorf("failed to store mailbox entry: %w", err)
+	}
+	if !s.due(userID, len(entry.Ciphertext)) {
+		return nil
+	}
+	return s.trim(ctx, userID, entry.SentAt)
+}
+
+// due counts a deposit and reports whether the mailbox should be trimmed.
+func (s *MailboxService) due(userID string, size int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.usage[userID]
+	if u == nil {
+		u = &mailboxUsage{}
+		s.usage[userID] = u
+	}
+	u.entries++
+	u.bytes += size
+	if u.entries < trimEvery(s.config.MaxEntries) && u.bytes < trimEvery(s.config.MaxBytes) {
+		return false
+	}
+	delete(s.usage, userID)
+	return true
+}
+
+func trimEvery(limit int) int {
+	return max(limit/8, 1)
+}
+
+// trim drops expired entries and the oldest ones beyond the size caps.
+func (s *MailboxService) trim(ctx context.Context, userID string, now time.Time) error {
+	entries, err := s.repo.List(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("could not retrieve mailbox: %w", err)
+	}
+	total := 0
+	for _, e := range entries {
+		total += len(e.Ciphertext)
+	}
+	var drop []string
+	for i, e := range entries {
+		over := len(entries)-i > s.config.MaxEntries || total > s.config.MaxBytes
+		if !over && !e.Expired(now) {
+			break
+		}
+		drop = append(drop, e.ID)
+		total -= len(e.Ciphertext)
+	}
+	if len(drop) > 0 {
+		if err := s.repo.Remove(ctx, userID, drop...); err != nil {
+			return fmt.Errorf("failed to trim mailbox: %w", err)
+		}
+	}
+	return nil
+}
+
+// Pending returns the user's unexpired entries, oldest first.
+func (s *MailboxService) Pending(ctx context.Context, userID string, now time.Time) ([]*domain.MailboxEntry, error) {
+	entries, err := s.repo.List(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not retrieve mailbox: %w", err)
+	}
+	var expired []string
+	pending := entries[:0]
+	for _, e := range entries {
+		if e.Expired(now) {
+			expired = append(expired, e.ID)
+			continue
+		}
+		pending = append(pending, e)
+	}
+	if len(expired) > 0 {
+		if err := s.repo.Remove(ctx, userID, expired...); err != nil {
+			return nil, fmt.Errorf("failed to remove expired mailbox entries: %w", err)
+		}
+	}
+	return pending, nil
+}
+
+// Acknowledge removes delivered entries from the user's mailbox.
+func (s *MailboxService) Acknowledge(ctx context.Context, userID string, entryIDs ...string) error {
+	return s.repo.Remove(ctx, userID, entryIDs...)
+}
+
+// EditMessage replaces the ciphertext of a stored message that its sender
+// edited.
+func (s *MailboxService) EditMessage(ctx context.Context, userID, messageID string, ciphertext []byte) error {
+	if err := s.repo.EditMessage(ctx, userID, messageID, ciphertext); err != nil {
+		return fmt.Errorf("failed to edit mailbox entry: %w", err)
+	}
+	return nil
+}
+
+// RemoveMessage drops a stored message that was deleted before the user
+// came back.
+func (s *MailboxService) RemoveMessage(ctx context.Context, userID, messageID string) error {
+	if err := s.repo.RemoveMessage(ctx, userID, messageID); err != nil {
+		return fmt.Errorf("failed to remove mailbox entry: %w", err)
+	}
+	return nil
+}
+
+// Clear empties the user's mailbox.
+func (s *MailboxService) Clear(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	delete(s.usage, userID)
+	s.mu.Unlock()
+	return s.repo.Clear(ctx, userID)
+}
@@ -0,0 +1,85 @@
+package application
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/server/internal/domain"
+	"chat-app/server/internal/infrastructure/persistence/inmemory"
+)
+
+func TestMailboxServiceDepositTrims(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   MailboxConfig
+		deposits int
+		size     int           // Ciphertext bytes per deposit
+		interval time.Duration // Between deposits
+		wantSeqs []uint64      // First and last seq left in the mailbox
+	}{
+		{
+			name:     "within the caps",
+			config:   MailboxConfig{TTL: time.Hour, MaxEntries: 16, MaxBytes: 1 << 10},
+			deposits: 10, size: 10,
+			wantSeqs: []uint64{1, 10},
+		},
+		{
+			name:     "entry cap trimmed on every deposit",
+			config:   MailboxConfig{TTL: time.Hour, MaxEntries: 8, MaxBytes: 1 << 10},
+			deposits: 12, size: 10,
+			wantSeqs: []uint64{5, 12},
+		},
+		{
+			name:     "entry cap exceeded until an eighth is deposited",
+			config:   MailboxConfig{TTL: time.Hour, MaxEntries: 16, MaxBytes: 1 << 10},
+			deposits: 19, size: 1,
+			wantSeqs: []uint64{3, 19},
+		},
+		{
+			name:     "entry cap trimmed after an eighth",
+			config:   MailboxConfig{TTL: time.Hour, MaxEntries: 16, MaxBytes: 1 << 10},
+			deposits: 20, size: 1,
+			wantSeqs: []uint64{5, 20},
+		},
+		{
+			name:     "byte cap",
+			config:   MailboxConfig{TTL: time.Hour, MaxEntries: 1000, MaxBytes: 80},
+			deposits: 12, size: 10,
+			wantSeqs: []uint64{5, 12},
+		},
+		{
+			name:     "expired entries",
+			config:   MailboxConfig{TTL: 5 * time.Minute, MaxEntries: 8, MaxBytes: 1 << 10},
+			deposits: 8, size: 1, interval: time.Minute,
+			wantSeqs: []uint64{4, 8},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := inmemory.NewInMemoryMailboxRepository()
+			service := NewMailboxService(repo, tt.config)
+			sentAt := time.Unix(1700000000, 0)
+			for seq := 1; seq <= tt.deposits; seq++ {
+				msg := domain.MailboxEntry{Seq: uint64(seq), SentAt: sentAt, Ciphertext: make([]byte, tt.size)}
+				if err := service.Deposit(ctx, "alice", msg); err != nil {
+					t.Fatal(err)
+				}
+				sentAt = sentAt.Add(tt.interval)
+			}
+
+			entries, err := repo.List(ctx, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) == 0 {
+				t.Fatalf("mailbox is empty, want seqs %v", tt.wantSeqs)
+			}
+			got := []uint64{entries[0].Seq, entries[len(entries)-1].Seq}
+			if got[0] != tt.wantSeqs[0] || got[1] != tt.wantSeqs[1] || len(entries) != int(got[1]-got[0])+1 {
+				t.Fatalf("mailbox holds %d entries from seq %d to %d, want seqs %v", len(entries), got[0], got[1], tt.wantSeqs)
+			}
+		})
+	}
+}
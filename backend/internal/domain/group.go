@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -11,12 +12,15 @@ import (
 var ErrMemberNotFound = errors.New("member not found in group")
 
 // Group represents a chat group.
+// A direct group is a private conversation between two users. It has no join
+// tag and no owner, and its ID is derived from the two user IDs.
 type Group struct {
 	ID                string
 	Name              string
 	JoinTag           string // Unique, user-friendly tag to join a group
 	ProfilePictureURL string
 	OwnerID           string
+	Direct            bool
 	Members           map[string]*User // Map of UserID to User
 	CreatedAt         time.Time
 	mu                sync.RWMutex
@@ -34,6 +38,24 @@ func NewGroup(id, name, joinTag, ownerID string) *Group {
 	}
 }
 
+// DirectGroupID returns the ID of the direct conversation between two users,
+// whichever order they are given in. The first ID is length-prefixed so that
+// IDs containing a colon cannot make two pairs share a conversation.
+func DirectGroupID(userA, userB string) string {
+	if userB < userA {
+		userA, userB = userB, userA
+	}
+	return "direct:" + strconv.Itoa(len(userA)) + ":" + userA + ":" + userB
+}
+
+// NewDirectGroup creates the direct conversation between two users, without
+// members.
+func NewDirectGroup(userA, userB string) *Group {
+	group := NewGroup(DirectGroupID(userA, userB), "", "", "")
+	group.Direct = true
+	return group
+}
+
 // AddMember adds a user to the group.
 func (g *Group) AddMember(user *User) {
 	g.mu.Lock()
@@ -78,6 +100,40 @@ func (g *Group) GetMemberIDs() []string {
 	return ids
 }
 
+// GetMembers returns a slice of all members.
+func (g *Group) GetMembers() []*User {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	members := make([]*User, 0, len(g.Members))
+	for _, member := range g.Members {
+		members = append(members, member)
+	}
+	return members
+}
+
+// HasMember checks if the user is a member of the group.
+func (g *Group) HasMember(userID string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.Members[userID]
+	return ok
+}
+
+// IsModerator reports whether the user may moderate the group, for example
+// by deleting other members' messages. Only the owner moderates for now.
+func (g *Group) IsModerator(userID string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.OwnerID == userID
+}
+
+// GetOwnerID returns the ID of the current owner.
+func (g *Group) GetOwnerID() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.OwnerID
+}
+
 // IsEmpty checks if the group has any members.
 func (g *Group) IsEmpty() bool {
 	g.mu.RLock()
@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// MailboxEntry is an encrypted group message held for a member who was
+// offline when it was sent.
+type MailboxEntry struct {
+	ID         string
+	UserID     string // Recipient
+	GroupID    string
+	SenderID   string
+	MessageID  string // Server ID of the message
+	ParentID   string // Thread the message replies to, if any
+	Mentions   []string
+	Seq        uint64 // Group sequence number of the original broadcast
+	SentAt     time.Time
+	ExpiresAt  time.Time
+	Ciphertext []byte
+	Edited     bool // The sender changed the ciphertext after sending
+}
+
+// Expired reports whether the entry is past its expiry time.
+func (e *MailboxEntry) Expired(now time.Time) bool {
+	return !now.Before(e.ExpiresAt)
+}
+
+// MailboxRepository defines the interface for per-user mailbox persistence.
+// Entries are kept in the order they were appended. EditMessage and
+// RemoveMessage find entries by message ID and do nothing if there are none.
+type MailboxRepository interface {
+	Append(ctx context.Context, entry *MailboxEntry) error
+	List(ctx context.Context, userID string) ([]*MailboxEntry, error) // Oldest first
+	Remove(ctx context.Context, userID string, entryIDs ...string) error
+	EditMessage(ctx context.Context, userID, messageID string, ciphertext []byte) error
+	RemoveMessage(ctx context.Context, userID, messageID string) error
+	Clear(ctx context.Context, userID string) error
+}
@@ -13,9 +13,18 @@ type User struct {
 	ProfilePictureURL string
 	PublicKey        string    // User's public identity key for E2EE
 	LastSeen         time.Time
+	Privacy          PrivacySettings
+	MutedGroups      map[string]bool // Groups whose notifications the user turned off
+	UnreadMentions   map[string]int  // Map of GroupID to mentions not yet acknowledged
 	mu               sync.RWMutex
 }
 
+// PrivacySettings controls what other users can learn about a user.
+type PrivacySettings struct {
+	HidePresence    bool // Appear offline to everyone and hide LastSeen
+	DisableReceipts bool // Neither send nor receive delivery and read receipts
+}
+
 // NewUser creates a new user instance.
 func NewUser(id, displayName, publicKey string) *User {
 	return &User{
@@ -38,6 +47,88 @@ func (u *User) UpdateProfile(displayName, profilePicURL string) {
 	}
 }
 
+// Touch records activity by the user.
+func (u *User) Touch(t time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if t.After(u.LastSeen) {
+		u.LastSeen = t
+	}
+}
+
+// GetLastSeen returns when the user was last active.
+func (u *User) GetLastSeen() time.Time {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.LastSeen
+}
+
+// GetPrivacy returns the user's privacy settings.
+func (u *User) GetPrivacy() PrivacySettings {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.Privacy
+}
+
+// SetPrivacy replaces the user's privacy settings.
+func (u *User) SetPrivacy(settings PrivacySettings) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.Privacy = settings
+}
+
+// SetMuted turns the user's notifications for a group off or back on.
+func (u *User) SetMuted(groupID string, muted bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !muted {
+		delete(u.MutedGroups, groupID)
+		return
+	}
+	if u.MutedGroups == nil {
+		u.MutedGroups = make(map[string]bool)
+	}
+	u.MutedGroups[groupID] = true
+}
+
+// IsMuted reports whether the user muted the group.
+func (u *User) IsMuted(groupID string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.MutedGroups[groupID]
+}
+
+// AddMention counts a mention of the user in a group and returns how many
+// are unread there.
+func (u *User) AddMention(groupID string) int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.UnreadMentions == nil {
+		u.UnreadMentions = make(map[string]int)
+	}
+	u.UnreadMentions[groupID]++
+	return u.UnreadMentions[groupID]
+}
+
+// ClearMentions marks the user's mentions in a group as read.
+func (u *User) ClearMentions(groupID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.UnreadMentions, groupID)
+}
+
+// GetUnreadMentions returns the unread mention count of every group that has
+// any.
+func (u *User) GetUnreadMentions() map[string]int {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	counts := make(map[string]int, len(u.UnreadMentions))
+	for groupID, n := range u.UnreadMentions {
+		counts[groupID] = n
+	}
+	return counts
+}
+
 // UserRepository defines the interface for user persistence.
 // This allows us to swap implementations (e.g., in-memory vs. Redis).
 type UserRepository interface {
@@ -0,0 +1,109 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock abstracts time so that timer-driven code can be driven by a fake
+// clock in tests.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call scheduled with AfterFunc.
+type Timer interface {
+	// Stop prevents the timer from firing. It returns false if the timer
+	// has already fired or been stopped.
+	Stop() bool
+}
+
+type realClock struct{}
+
+// New returns a Clock backed by the time package.
+func New() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Fake is a Clock that only moves when Advance is called.
+type Fake struct {
+	now    time.Time
+	timers []*fakeTimer
+	mu     sync.Mutex
+}
+
+// NewFake creates a fake clock set to the given time.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t := &fakeTimer{clock: f, when: f.now.Add(d), fn: fn}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Advance moves the clock forward and synchronously runs every timer that
+// has come due, in the order they were due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	var due, pending []*fakeTimer
+	for _, t := range f.timers {
+		if !t.when.After(f.now) {
+			due = append(due, t)
+		} else {
+			pending = append(pending, t)
+		}
+	}
+	f.timers = pending
+	f.mu.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool { return due[i].when.Before(due[j].when) })
+	for _, t := range due {
+		t.fn()
+	}
+}
+
+// Pending returns the number of timers that have not fired or been stopped.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+type fakeTimer struct {
+	clock *Fake
+	when  time.Time
+	fn    func()
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	for i, pending := range t.clock.timers {
+		if pending == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -28,6 +28,11 @@ func (r *InMemoryGroupRepository) Add(ctx context.Context, group *domain.Group)
 	if _, ok := r.groups[group.ID]; ok {
 		return fmt.Errorf("group with ID %s already exists", group.ID)
 	}
+	if group.JoinTag == "" {
+		// Direct conversations have no tag to index.
+		r.groups[group.ID] = group
+		return nil
+	}
 	if _, ok := r.tags[group.JoinTag]; ok {
 		return fmt.Errorf("group with tag %s already exists", group.JoinTag)
 	}
@@ -63,7 +68,9 @@ func (r *InMemoryGroupRepository) Remove(ctx context.Context, id string) error {
 	if !ok {
 		return fmt.Errorf("group with ID %s not found", id)
 	}
-	delete(r.tags, group.JoinTag)
+	if group.JoinTag != "" {
+		delete(r.tags, group.JoinTag)
+	}
 	delete(r.groups, id)
 	return nil
 }
@@ -0,0 +1,96 @@
+package inmemory
+
+import (
+	"context"
+	"sync"
+	"chat-app/server/internal/domain"
+)
+
+// InMemoryMailboxRepository is an in-memory implementation of MailboxRepository.
+type InMemoryMailboxRepository struct {
+	mailboxes map[string][]*domain.MailboxEntry // Map of UserID to entries, oldest first
+	mu        sync.RWMutex
+}
+
+// NewInMemoryMailboxRepository creates a new in-memory mailbox repository.
+func NewInMemoryMailboxRepository() *InMemoryMailboxRepository {
+	return &InMemoryMailboxRepository{
+		mailboxes: make(map[string][]*domain.MailboxEntry),
+	}
+}
+
+func (r *InMemoryMailboxRepository) Append(ctx context.Context, entry *domain.MailboxEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mailboxes[entry.UserID] = append(r.mailboxes[entry.UserID], entry)
+	return nil
+}
+
+func (r *InMemoryMailboxRepository) List(ctx context.Context, userID string) ([]*domain.MailboxEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]*domain.MailboxEntry, len(r.mailboxes[userID]))
+	copy(entries, r.mailboxes[userID])
+	return entries, nil
+}
+
+func (r *InMemoryMailboxRepository) Remove(ctx context.Context, userID string, entryIDs ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	remove := make(map[string]bool, len(entryIDs))
+	for _, id := range entryIDs {
+		remove[id] = true
+	}
+	kept := r.mailboxes[userID][:0]
+	for _, entry := range r.mailboxes[userID] {
+		if !remove[entry.ID] {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		delete(r.mailboxes, userID)
+		return nil
+	}
+	r.mailboxes[userID] = kept
+	return nil
+}
+
+func (r *InMemoryMailboxRepository) EditMessage(ctx context.Context, userID, messageID string, ciphertext []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, entry := range r.mailboxes[userID] {
+		if entry.MessageID == messageID {
+			// Entries handed out by List are shared, so they are replaced
+			// rather than changed in place.
+			edited := *entry
+			edited.Ciphertext = ciphertext
+			edited.Edited = true
+			r.mailboxes[userID][i] = &edited
+		}
+	}
+	return nil
+}
+
+func (r *InMemoryMailboxRepository) RemoveMessage(ctx context.Context, userID, messageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.mailboxes[userID][:0]
+	for _, entry := range r.mailboxes[userID] {
+		if entry.MessageID != messageID {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		delete(r.mailboxes, userID)
+		return nil
+	}
+	r.mailboxes[userID] = kept
+	return nil
+}
+
+func (r *InMemoryMailboxRepository) Clear(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.mailboxes, userID)
+	return nil
+}
@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"context"
+	"chat-app/server/internal/domain"
+)
+
+// RedisMailboxRepository is a placeholder for a Redis-backed mailbox repository.
+type RedisMailboxRepository struct {
+	// redisClient *redis.Client
+}
+
+// NewRedisMailboxRepository creates a new Redis mailbox repository.
+func NewRedisMailboxRepository() *RedisMailboxRepository {
+	return &RedisMailboxRepository{}
+}
+
+func (r *RedisMailboxRepository) Append(ctx context.Context, entry *domain.MailboxEntry) error {
+	// PUNTED: Implementation would serialize the entry and RPUSH it.
+	// e.g., RPUSH mailbox:{userId} {entry}, then PEXPIREAT mailbox:{userId} {expiresAt}
+	return nil
+}
+
+func (r *RedisMailboxRepository) List(ctx context.Context, userID string) ([]*domain.MailboxEntry, error) {
+	// PUNTED: Implementation would use LRANGE over the whole list.
+	// e.g., LRANGE mailbox:{userId} 0 -1
+	return nil, nil
+}
+
+func (r *RedisMailboxRepository) Remove(ctx context.Context, userID string, entryIDs ...string) error {
+	// PUNTED: Entries would also be indexed by ID in a hash so that acked
+	// ones can be removed without scanning, e.g. HDEL plus LREM per entry.
+	return nil
+}
+
+func (r *RedisMailboxRepository) EditMessage(ctx context.Context, userID, messageID string, ciphertext []byte) error {
+	// PUNTED: A second hash from message ID to entry ID would find the entry,
+	// e.g. HGET mailbox:{userId}:messages {messageId}, then rewrite it with HSET.
+	return nil
+}
+
+func (r *RedisMailboxRepository) RemoveMessage(ctx context.Context, userID, messageID string) error {
+	// PUNTED: Same lookup as EditMessage, followed by the removal in Remove.
+	return nil
+}
+
+func (r *RedisMailboxRepository) Clear(ctx context.Context, userID string) error {
+	// PUNTED: Implementation would use Redis DEL.
+	// e.g., DEL mailbox:{userId}
+	return nil
+}
@@ -42,6 +42,19 @@ func NewRouter(hub *websocket.Hub, jwtService *auth.JWTService, chatService *app
 	return r
 }
 
+// NewInternalRouter sets up the operational routes, such as transport
+// metrics. It is meant for a listener that is not reachable from the public
+// network.
+func NewInternalRouter(hub *websocket.Hub) http.Handler {
+	r := chi.NewRouter()
+
+	r.Use(middleware.Recoverer)
+
+	r.Get("/ws/stats", wsStatsHandler(hub))
+
+	return r
+}
+
 type issueTokenRequest struct {
 	UserID string `json:"userId"`
 }
@@ -109,3 +122,10 @@ func searchGroupsHandler(chatService *application.ChatService) http.HandlerFunc
 		json.NewEncoder(w).Encode(results)
 	}
 }
+
+func wsStatsHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(hub.Stats())
+	}
+}
@@ -0,0 +1,83 @@
+package websocket
+
+import "sync/atomic"
+
+// CloseSlowConsumer is the close code sent to a client that is disconnected
+// because it did not read its messages fast enough.
+const CloseSlowConsumer = 4008
+
+// OverflowAction decides what happens when a client's send buffer is full.
+type OverflowAction int
+
+const (
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest OverflowAction = iota
+	// DropNewest discards the new message and later tells the client how
+	// many messages it missed with a messages_dropped frame.
+	DropNewest
+	// Disconnect closes the connection with CloseSlowConsumer.
+	Disconnect
+)
+
+func (a OverflowAction) String() string {
+	switch a {
+	case DropOldest:
+		return "drop_oldest"
+	case DropNewest:
+		return "drop_newest"
+	case Disconnect:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// SendPolicy configures the outbound buffer of a class of connections.
+type SendPolicy struct {
+	BufferSize int
+	Overflow   OverflowAction
+}
+
+// DefaultSendPolicy applies to connections whose class has no policy of its own.
+var DefaultSendPolicy = SendPolicy{BufferSize: 256, Overflow: DropNewest}
+
+// SendStats counts how often each overflow action has fired.
+type SendStats struct {
+	DroppedOldest uint64 `json:"droppedOldest"`
+	DroppedNewest uint64 `json:"droppedNewest"`
+	Disconnected  uint64 `json:"disconnected"`
+}
+
+type sendCounters struct {
+	droppedOldest atomic.Uint64
+	droppedNewest atomic.Uint64
+	disconnected  atomic.Uint64
+}
+
+func (c *sendCounters) snapshot() SendStats {
+	return SendStats{
+		DroppedOldest: c.droppedOldest.Load(),
+		DroppedNewest: c.droppedNewest.Load(),
+		Disconnected:  c.disconnected.Load(),
+	}
+}
+
+// WithSendPolicy sets the send policy for connections of the given class.
+// Clients pick their class with the "class" query parameter on /ws, and the
+// empty class is the default for everyone else.
+func WithSendPolicy(class string, policy SendPolicy) Option {
+	return func(h *Hub) { h.sendPolicies[class] = policy }
+}
+
+// sendPolicy returns the policy for a connection class.
+func (h *Hub) sendPolicy(class string) SendPolicy {
+	if policy, ok := h.sendPolicies[class]; ok {
+		return policy
+	}
+	return h.sendPolicies[""]
+}
+
+// SendStats returns how often each overflow action has fired since startup.
+func (h *Hub) SendStats() SendStats {
+	return h.sendCounters.snapshot()
+}
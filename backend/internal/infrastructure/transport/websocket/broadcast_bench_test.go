@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// discardConn is a net.Conn that throws away everything written to it, so
+// the benchmarks measure encoding and framing rather than the network.
+type discardConn struct{}
+
+func (discardConn) Read(b []byte) (int, error)         { return 0, io.EOF }
+func (discardConn) Write(b []byte) (int, error)        { return len(b), nil }
+func (discardConn) Close() error                       { return nil }
+func (discardConn) LocalAddr() net.Addr                { return &net.TCPAddr{} }
+func (discardConn) RemoteAddr() net.Addr               { return &net.TCPAddr{} }
+func (discardConn) SetDeadline(t time.Time) error      { return nil }
+func (discardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (discardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// hijackRecorder lets the upgrader take over a discardConn.
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn := discardConn{}
+	return conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)), nil
+}
+
+func newDiscardClients(b *testing.B, n int) []*Client {
+	b.Helper()
+	upgrader := websocket.Upgrader{}
+	clients := make([]*Client, n)
+	for i := range clients {
+		r := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		r.Header.Set("Connection", "upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		conn, err := upgrader.Upgrade(hijackRecorder{httptest.NewRecorder()}, r, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		clients[i] = &Client{conn: conn, codec: jsonCodec{}}
+	}
+	return clients
+}
+
+func benchmarkMessage() OutgoingMessage {
+	return OutgoingMessage{
+		Type: TypeNewMessage,
+		Payload: NewMessagePayload{
+			GroupID:    "5f1c2e9a-0b7d-4c1e-9a3f-2d6b8e4c7a10",
+			SenderID:   "9b2d4f6a-8c1e-4a3b-b5d7-e9f1a2c3d4e5",
+			Ciphertext: []byte(strings.Repeat("x", 512)),
+		},
+	}
+}
+
+var benchmarkGroupSizes = []int{10, 100, 1000}
+
+// BenchmarkBroadcastPerRecipient encodes the message once per recipient,
+// the way fan-out worked before frames were shared.
+func BenchmarkBroadcastPerRecipient(b *testing.B) {
+	for _, size := range benchmarkGroupSizes {
+		b.Run(fmt.Sprintf("members=%d", size), func(b *testing.B) {
+			clients := newDiscardClients(b, size)
+			msg := benchmarkMessage()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, c := range clients {
+					if err := c.write(outbound{msg: msg}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBroadcastShared encodes the message once per broadcast and writes
+// the shared prepared frame to every recipient.
+func BenchmarkBroadcastShared(b *testing.B) {
+	for _, size := range benchmarkGroupSizes {
+		b.Run(fmt.Sprintf("members=%d", size), func(b *testing.B) {
+			clients := newDiscardClients(b, size)
+			msg := benchmarkMessage()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				frame := newSharedFrame(msg)
+				for _, c := range clients {
+					if err := c.write(outbound{shared: frame}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+// FrameConfig bounds the size of incoming frames. A frame may be at most
+// MaxFrameSize bytes once decompressed, which must be positive; a larger one
+// closes the connection with 1009 (message too big). Payloads are limited by
+// type to Types or Default bytes, and a limit of zero means MaxFrameSize.
+//
+// A payload within its type's limit but too large for one frame is uploaded
+// in pieces: chunk_start declares the type and size, chunk frames carry the
+// bytes in order, and chunk_end handles the reassembled payload as a frame of
+// that type. A connection may have MaxUploads uploads open holding at most
+// MaxUploadBytes between them, and an upload that is not ended within
+// UploadTimeout is dropped.
+type FrameConfig struct {
+	MaxFrameSize int
+	Default      int
+	Types        map[string]int // Map frame type to its payload limit in bytes
+
+	MaxUploads     int
+	MaxUploadBytes int
+	UploadTimeout  time.Duration
+}
+
+// DefaultFrameConfig lets messages and key bundles grow well past a single
+// frame while keeping the control frames small.
+var DefaultFrameConfig = FrameConfig{
+	MaxFrameSize: 16 << 10,
+	Default:      4 << 10,
+	Types: map[string]int{
+		TypeAuthenticate:      16 << 10,
+		TypeSendMessage:       256 << 10,
+		TypeEditMessage:       256 << 10,
+		TypeKeyExchangeOffer:  64 << 10,
+		TypeKeyExchangeAnswer: 64 << 10,
+		TypeUpdateProfile:     16 << 10,
+	},
+	MaxUploads:     4,
+	MaxUploadBytes: 1 << 20,
+	UploadTimeout:  30 * time.Second,
+}
+
+// WithFrameLimits configures the size limits on incoming frames.
+func WithFrameLimits(config FrameConfig) Option {
+	return func(h *Hub) { h.frames = config }
+}
+
+// limit returns the largest payload accepted for a frame type. Chunks are
+// only bound by the frame size.
+func (c FrameConfig) limit(msgType string) int {
+	n, ok := c.Types[msgType]
+	switch {
+	case msgType == TypeChunkStart || msgType == TypeChunk || msgType == TypeChunkEnd:
+		n = c.MaxFrameSize
+	case !ok:
+		n = c.Default
+	}
+	if n <= 0 {
+		return c.MaxFrameSize
+	}
+	return n
+}
+
+var errFrameTooLarge = errors.New("frame too large")
+
+// readFrame reads a whole frame of at most limit bytes. The connection's
+// read limit counts the bytes on the wire, so a compressed frame has to be
+// bounded again as it is inflated.
+func readFrame(r io.Reader, limit int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err == nil && len(data) > limit {
+		return nil, errFrameTooLarge
+	}
+	return data, err
+}
+
+// upload is a payload being reassembled from chunks.
+type upload struct {
+	msgType string
+	size    int
+	data    []byte
+	timer   clock.Timer
+}
+
+var errUnknownUpload = &Error{Code: CodeInvalidPayload, Message: "unknown upload"}
+
+func tooLarge(msgType string, limit int) *Error {
+	return &Error{Code: CodeFrameTooLarge, Message: fmt.Sprintf("%s payloads are limited to %d bytes", msgType, limit)}
+}
+
+// handleChunkStart opens an upload. The size is reserved against the
+// connection's upload allowance up front, so uploads cannot outgrow it.
+// Until the connection authenticates, only authenticate can be uploaded.
+func (h *Hub) handleChunkStart(ctx context.Context, req *Request) error {
+	body := req.Body.(*ChunkStartPayload)
+	if body.UploadID == "" || body.Type == "" || body.Size <= 0 {
+		return invalidPayload("uploadId, type and a positive size are required")
+	}
+	switch body.Type {
+	case TypeChunkStart, TypeChunk, TypeChunkEnd:
+		return invalidPayload("chunks cannot be uploaded in chunks")
+	}
+	// Before it authenticates, a connection may only upload its authenticate
+	// frame, so that it cannot tie up buffers for frames it may not send.
+	if req.UserID == "" && body.Type != TypeAuthenticate {
+		return &Error{Code: CodeUnauthenticated, Message: "not authenticated"}
+	}
+	if limit := h.frames.limit(body.Type); body.Size > limit {
+		return tooLarge(body.Type, limit)
+	}
+
+	if err := req.Client.startUpload(h, body); err != nil {
+		return err
+	}
+	req.Ack()
+	return nil
+}
+
+func (c *Client) startUpload(h *Hub, body *ChunkStartPayload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.uploads[body.UploadID] != nil {
+		return invalidPayload("upload already in progress")
+	}
+	if len(c.uploads) >= h.frames.MaxUploads || c.uploadBytes+body.Size > h.frames.MaxUploadBytes {
+		return &Error{Code: CodeLimitReached, Message: "too many uploads in progress"}
+	}
+	if c.uploads == nil {
+		c.uploads = make(map[string]*upload)
+	}
+	up := &upload{msgType: body.Type, size: body.Size}
+	uploadID := body.UploadID
+	up.timer = h.clock.AfterFunc(h.frames.UploadTimeout, func() { h.expireUpload(c, uploadID, up) })
+	c.uploads[uploadID] = up
+	c.uploadBytes += up.size
+	return nil
+}
+
+// handleChunk appends the next piece of an upload. An upload that would
+// exceed its declared size is dropped.
+func (h *Hub) handleChunk(ctx context.Context, req *Request) error {
+	body := req.Body.(*ChunkPayload)
+	if err := req.Client.appendUpload(body); err != nil {
+		return err
+	}
+	req.Ack()
+	return nil
+}
+
+func (c *Client) appendUpload(body *ChunkPayload) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	up := c.uploads[body.UploadID]
+	if up == nil {
+		return errUnknownUpload
+	}
+	if len(up.data)+len(body.Data) > up.size {
+		c.dropUploadLocked(body.UploadID, up)
+		return invalidPayload(fmt.Sprintf("upload is larger than the declared %d bytes", up.size))
+	}
+	if up.data == nil {
+		up.data = make([]byte, 0, up.size)
+	}
+	up.data = append(up.data, body.Data...)
+	return nil
+}
+
+// handleChunkEnd closes an upload and handles the reassembled payload as if
+// it had arrived in one frame, rate limits included.
+func (h *Hub) handleChunkEnd(ctx context.Context, req *Request) error {
+	body := req.Body.(*ChunkEndPayload)
+	c := req.Client
+	c.mu.Lock()
+	up := c.uploads[body.UploadID]
+	if up != nil {
+		c.dropUploadLocked(body.UploadID, up)
+	}
+	c.mu.Unlock()
+	if up == nil {
+		return errUnknownUpload
+	}
+	if len(up.data) != up.size {
+		return invalidPayload(fmt.Sprintf("upload is incomplete: got %d of %d bytes", len(up.data), up.size))
+	}
+	h.handleMessage(c, IncomingMessage{ID: req.ID, Type: up.msgType, Payload: up.data})
+	return nil
+}
+
+// expireUpload drops an upload that was not ended in time.
+func (h *Hub) expireUpload(c *Client, uploadID string, up *upload) {
+	c.mu.Lock()
+	expired := c.uploads[uploadID] == up
+	if expired {
+		c.dropUploadLocked(uploadID, up)
+	}
+	c.mu.Unlock()
+	if expired {
+		c.enqueue(errorMessage(up.msgType, &Error{Code: CodeUploadExpired, Message: fmt.Sprintf("upload %q timed out", uploadID)}))
+	}
+}
+
+// dropUploadLocked removes an upload and releases its reservation. The
+// caller holds c.mu.
+func (c *Client) dropUploadLocked(uploadID string, up *upload) {
+	up.timer.Stop()
+	delete(c.uploads, uploadID)
+	c.uploadBytes -= up.size
+}
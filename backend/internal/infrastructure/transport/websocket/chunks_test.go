@@ -0,0 +1,177 @@
+package websocket
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chunkTestLimits keeps frames small so that payloads of a few KiB need to
+// be uploaded in chunks.
+var chunkTestLimits = FrameConfig{
+	MaxFrameSize:   1024,
+	Default:        256,
+	Types:          map[string]int{TypeAuthenticate: 2048, TypeCreateGroup: 4096},
+	MaxUploads:     2,
+	MaxUploadBytes: 6000,
+	UploadTimeout:  10 * time.Second,
+}
+
+// upload sends payload as an upload of msgType in pieces of 500 bytes, and
+// ends it with the given correlation ID.
+func (c *testConn) upload(uploadID, msgType string, payload []byte, endID string) {
+	c.t.Helper()
+	c.send(TypeChunkStart, ChunkStartPayload{UploadID: uploadID, Type: msgType, Size: len(payload)})
+	c.expect(TypeAck)
+	for i := 0; i < len(payload); i += 500 {
+		c.send(TypeChunk, ChunkPayload{UploadID: uploadID, Data: payload[i:min(i+500, len(payload))]})
+		c.expect(TypeAck)
+	}
+	c.request(endID, TypeChunkEnd, ChunkEndPayload{UploadID: uploadID})
+}
+
+func marshalJSON(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestChunkedUpload checks that a reassembled payload is handled like a
+// frame of its type, answered with chunk_end's correlation ID.
+func TestChunkedUpload(t *testing.T) {
+	srv := newTestServer(t, WithFrameLimits(chunkTestLimits))
+	alice := srv.connect("alice")
+	name := strings.Repeat("n", 3000)
+	alice.upload("u1", TypeCreateGroup, marshalJSON(t, CreateGroupPayload{Name: name, JoinTag: "big"}), "end")
+
+	frame := alice.expect(TypeGroupCreated)
+	var view GroupView
+	frame.decode(alice, &view)
+	if frame.ID != "end" || view.Name != name {
+		t.Fatalf("group_created with ID %q and a name of %d bytes, want ID %q and %d bytes", frame.ID, len(view.Name), "end", len(name))
+	}
+}
+
+// TestChunkedAuthenticate checks that a connection can upload its
+// authenticate frame before it is authenticated.
+func TestChunkedAuthenticate(t *testing.T) {
+	srv := newTestServer(t, WithFrameLimits(chunkTestLimits))
+	c := srv.dial()
+	publicKey := strings.Repeat("k", 1500)
+	c.upload("auth", TypeAuthenticate, marshalJSON(t, AuthenticatePayload{DisplayName: "alice", PublicKey: publicKey}), "end")
+
+	var auth AuthenticatedPayload
+	c.expect(TypeAuthenticated).decode(c, &auth)
+	if auth.User.PublicKey != publicKey {
+		t.Fatalf("registered a public key of %d bytes, want %d", len(auth.User.PublicKey), len(publicKey))
+	}
+}
+
+func TestChunkUploadRejected(t *testing.T) {
+	tests := []struct {
+		name      string
+		anonymous bool              // Only say hello
+		run       func(c *testConn) // Ends with the frame that fails
+		wantCode  string
+	}{
+		{
+			name:      "target type before authenticating",
+			anonymous: true,
+			run: func(c *testConn) {
+				c.send(TypeChunkStart, ChunkStartPayload{UploadID: "u1", Type: TypeSendMessage, Size: 100})
+			},
+			wantCode: CodeUnauthenticated,
+		},
+		{
+			name: "larger than the type allows",
+			run: func(c *testConn) {
+				c.send(TypeChunkStart, ChunkStartPayload{UploadID: "u1", Type: TypeCreateGroup, Size: 4097})
+			},
+			wantCode: CodeFrameTooLarge,
+		},
+		{
+			name: "too many uploads",
+			run: func(c *testConn) {
+				for _, id := range []string{"u1", "u2"} {
+					c.send(TypeChunkStart, ChunkStartPayload{UploadID: id, Type: TypeCreateGroup, Size: 100})
+					c.expect(TypeAck)
+				}
+				c.send(TypeChunkStart, ChunkStartPayload{UploadID: "u3", Type: TypeCreateGroup, Size: 100})
+			},
+			wantCode: CodeLimitReached,
+		},
+		{
+			name: "more bytes than the connection may reserve",
+			run: func(c *testConn) {
+				c.send(TypeChunkStart, ChunkStartPayload{UploadID: "u1", Type: TypeCreateGroup, Size: 4000})
+				c.expect(TypeAck)
+				c.send(TypeChunkStart, ChunkStartPayload{UploadID: "u2", Type: TypeCreateGroup, Size: 4000})
+			},
+			wantCode: CodeLimitReached,
+		},
+		{
+			name: "chunks past the declared size",
+			run: func(c *testConn) {
+				c.send(TypeChunkStart, ChunkStartPayload{UploadID: "u1", Type: TypeCreateGroup, Size: 10})
+				c.expect(TypeAck)
+				c.send(TypeChunk, ChunkPayload{UploadID: "u1", Data: []byte("01234567890")})
+			},
+			wantCode: CodeInvalidPayload,
+		},
+		{
+			name: "ended before every chunk arrived",
+			run: func(c *testConn) {
+				c.send(TypeChunkStart, ChunkStartPayload{UploadID: "u1", Type: TypeCreateGroup, Size: 10})
+				c.expect(TypeAck)
+				c.send(TypeChunk, ChunkPayload{UploadID: "u1", Data: []byte("01234")})
+				c.expect(TypeAck)
+				c.send(TypeChunkEnd, ChunkEndPayload{UploadID: "u1"})
+			},
+			wantCode: CodeInvalidPayload,
+		},
+		{
+			name: "unknown upload",
+			run: func(c *testConn) {
+				c.send(TypeChunk, ChunkPayload{UploadID: "u1", Data: []byte("0")})
+			},
+			wantCode: CodeInvalidPayload,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, WithFrameLimits(chunkTestLimits))
+			c := srv.dial()
+			if !tt.anonymous {
+				c = srv.login(c, AuthenticatePayload{DisplayName: "alice", PublicKey: "key"})
+			}
+			tt.run(c)
+			if got := c.expectError(); got.Code != tt.wantCode {
+				t.Fatalf("got %s (%s), want %s", got.Code, got.Message, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestChunkUploadExpires checks that an upload that is not ended in time is
+// dropped and its reservation released.
+func TestChunkUploadExpires(t *testing.T) {
+	srv := newTestServer(t, WithFrameLimits(chunkTestLimits))
+	alice := srv.connect("alice")
+	alice.send(TypeChunkStart, ChunkStartPayload{UploadID: "u1", Type: TypeCreateGroup, Size: 4000})
+	alice.expect(TypeAck)
+
+	srv.clock.Advance(chunkTestLimits.UploadTimeout)
+	if got := alice.expectError(); got.Code != CodeUploadExpired {
+		t.Fatalf("got %s, want %s", got.Code, CodeUploadExpired)
+	}
+	alice.send(TypeChunk, ChunkPayload{UploadID: "u1", Data: []byte("0")})
+	if got := alice.expectError(); got.Code != CodeInvalidPayload {
+		t.Fatalf("chunk for the expired upload got %s, want %s", got.Code, CodeInvalidPayload)
+	}
+	alice.send(TypeChunkStart, ChunkStartPayload{UploadID: "u2", Type: TypeCreateGroup, Size: 4000})
+	alice.expect(TypeAck)
+}
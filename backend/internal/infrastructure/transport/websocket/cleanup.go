@@ -0,0 +1,59 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+// groupCleaner deletes groups that stay empty for longer than a timeout.
+// Scheduling a group that is already pending restarts its timer.
+type groupCleaner struct {
+	clock   clock.Clock
+	timeout time.Duration
+	expire  func(groupID string)
+	timers  map[string]clock.Timer
+	mu      sync.Mutex
+}
+
+func newGroupCleaner(c clock.Clock, timeout time.Duration, expire func(groupID string)) *groupCleaner {
+	return &groupCleaner{
+		clock:   c,
+		timeout: timeout,
+		expire:  expire,
+		timers:  make(map[string]clock.Timer),
+	}
+}
+
+// schedule starts the deletion countdown for an empty group.
+func (c *groupCleaner) schedule(groupID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if timer, ok := c.timers[groupID]; ok {
+		timer.Stop()
+	}
+	var timer clock.Timer
+	timer = c.clock.AfterFunc(c.timeout, func() {
+		c.mu.Lock()
+		current := c.timers[groupID] == timer
+		if current {
+			delete(c.timers, groupID)
+		}
+		c.mu.Unlock()
+		if current {
+			c.expire(groupID)
+		}
+	})
+	c.timers[groupID] = timer
+}
+
+// cancel stops a pending deletion, for example because someone rejoined.
+func (c *groupCleaner) cancel(groupID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if timer, ok := c.timers[groupID]; ok {
+		timer.Stop()
+		delete(c.timers, groupID)
+	}
+}
@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"chat-app/server/internal/application"
+	"chat-app/server/internal/infrastructure/auth"
+	"chat-app/server/internal/infrastructure/clock"
+	"chat-app/server/internal/infrastructure/persistence/inmemory"
+)
+
+// newTestHub returns a hub driven by a fake clock. It is not run, so
+// handlers are called directly.
+func newTestHub(t *testing.T, opts ...Option) (*Hub, *clock.Fake) {
+	t.Helper()
+	fake := clock.NewFake(time.Unix(1700000000, 0))
+	chatService := application.NewChatService(inmemory.NewInMemoryUserRepository(), inmemory.NewInMemoryGroupRepository())
+	jwtService := auth.NewJWTService("test-secret", time.Hour)
+	return NewHub(chatService, jwtService, append([]Option{WithClock(fake)}, opts...)...), fake
+}
+
+// newTestClient returns a client without a connection whose frames pile up
+// in its send buffer.
+func newTestClient(h *Hub, userID string) *Client {
+	return &Client{hub: h, send: make(chan outbound, 64), policy: DefaultSendPolicy, UserID: userID}
+}
+
+func TestEmptyGroupCleanup(t *testing.T) {
+	tests := []struct {
+		name        string
+		rejoinAfter time.Duration // Zero for no rejoin
+		wantDeleted bool
+	}{
+		{name: "deleted after the timeout", wantDeleted: true},
+		{name: "rejoin cancels the deletion", rejoinAfter: groupCleanupTimeout / 2, wantDeleted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, fake := newTestHub(t)
+			ctx := context.Background()
+			if _, err := h.chatService.RegisterUser(ctx, "alice", "Alice", "key"); err != nil {
+				t.Fatal(err)
+			}
+			group, err := h.chatService.CreateGroup(ctx, "group", "tag", "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			h.groupLog(group.ID)
+			h.groups[group.ID] = make(map[*Client]bool)
+
+			if err := h.leaveGroup(ctx, group.ID, "alice"); err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Duration(0)
+			if tt.rejoinAfter > 0 {
+				fake.Advance(tt.rejoinAfter)
+				elapsed = tt.rejoinAfter
+				req := &Request{
+					Hub:    h,
+					Client: newTestClient(h, "alice"),
+					UserID: "alice",
+					Type:   TypeJoinGroup,
+					Body:   &JoinGroupPayload{GroupID: group.ID},
+				}
+				if err := h.handleJoinGroup(ctx, req); err != nil {
+					t.Fatal(err)
+				}
+				if n := len(h.cleanup.timers); n != 0 {
+					t.Fatalf("%d cleanup timers pending after rejoin, want 0", n)
+				}
+			}
+			fake.Advance(groupCleanupTimeout - elapsed)
+
+			_, err = h.chatService.GetGroup(ctx, group.ID)
+			if deleted := err != nil; deleted != tt.wantDeleted {
+				t.Fatalf("group deleted from repository = %v, want %v", deleted, tt.wantDeleted)
+			}
+			_, hasGroup := h.groups[group.ID]
+			_, hasLog := h.logs[group.ID]
+			if hasGroup == tt.wantDeleted || hasLog == tt.wantDeleted {
+				t.Fatalf("hub still tracks group = %v, log = %v; want %v", hasGroup, hasLog, !tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -1,26 +1,234 @@
 package websocket
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
+	"chat-app/server/internal/infrastructure/clock"
 	"github.com/gorilla/websocket"
 )
 
 const (
-	writeWait      = 10 * time.Second
-	pongWait       = 60 * time.Second
-	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = (pongWait * 9) / 10
 )
 
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
 	hub    *Hub
 	conn   *websocket.Conn
-	send   chan OutgoingMessage
-	UserID string // Authenticated user ID
+	send   chan outbound
+	codec  Codec // Wire format negotiated through the subprotocol
+	policy SendPolicy
+	wire   *countingConn // Counts bytes written to the network, may be nil
+
+	compress          bool // permessage-deflate was negotiated
+	compressThreshold int
+	UserID            string // Authenticated user ID
+
+	resumeToken string      // Token that resumes this session, guarded by hub.mu
+	expiry      clock.Timer // Ends a detached session, guarded by hub.mu
+
+	frames tokenBucket // All frames read from the connection, guarded by hub.limiter.mu
+	rate   *rateState  // Rate limits before authentication, guarded by hub.limiter.mu
+
+	mu            sync.Mutex // Guards the fields below and sends on the send channel
+	closed        bool
+	closeCode     int // Close code for the write pump to send, if any
+	dropped       int // Messages dropped since the last messages_dropped notice
+	version       int // Negotiated protocol version, 0 until hello
+	buckets       map[*rateLimit]*tokenBucket
+	detached      bool       // The connection is gone but the session may be resumed
+	missed        []outbound // Frames queued while detached, oldest first
+	missedLimit   int
+	missedDropped int                // Frames pushed out of missed because it was full
+	mailboxPage   map[string]bool    // Mailbox entries sent and not yet acknowledged
+	uploads       map[string]*upload // Map upload ID to a chunked upload in progress
+	uploadBytes   int                // Bytes reserved by uploads
+}
+
+func (c *Client) protocolVersion() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+func (c *Client) setVersion(version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = version
+}
+
+// enqueue queues a message for the write pump without blocking the caller.
+// When the send buffer is full, the client's send policy decides what gives.
+// It reports whether msg was queued.
+func (c *Client) enqueue(msg OutgoingMessage) bool {
+	return c.enqueueOutbound(outbound{msg: msg})
+}
+
+// enqueueShared queues a frame that is shared with other recipients.
+func (c *Client) enqueueShared(frame *sharedFrame) bool {
+	return c.enqueueOutbound(outbound{shared: frame})
+}
+
+// enqueueTransient queues a shared frame that is useless later, such as a
+// typing indicator. A detached session does not keep it.
+func (c *Client) enqueueTransient(frame *sharedFrame) bool {
+	return c.enqueueOutbound(outbound{shared: frame, transient: true})
+}
+
+func (c *Client) enqueueOutbound(msg outbound) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.detached {
+		if msg.transient {
+			return false
+		}
+		c.keepMissedLocked(msg)
+		return true
+	}
+	if c.closed {
+		return false
+	}
+	select {
+	case c.send <- msg:
+		return true
+	default:
+	}
+
+	counters := &c.hub.sendCounters
+	switch c.policy.Overflow {
+	case DropOldest:
+		// Only enqueue sends on the channel, and it holds c.mu, so once one
+		// message is gone there is room for msg.
+		select {
+		case <-c.send:
+			counters.droppedOldest.Add(1)
+		default:
+		}
+		select {
+		case c.send <- msg:
+			return true
+		default:
+			return false
+		}
+	case Disconnect:
+		counters.disconnected.Add(1)
+		log.Println("disconnecting slow consumer")
+		c.closeCode = CloseSlowConsumer
+		c.closeLocked()
+		return false
+	default:
+		counters.droppedNewest.Add(1)
+		c.dropped++
+		return false
+	}
+}
+
+// takeDropped returns and resets the number of messages dropped by DropNewest.
+func (c *Client) takeDropped() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.dropped
+	c.dropped = 0
+	return n
+}
+
+// detach switches a dropped client to keeping its frames for a later resume,
+// including any the write pump had not written yet, and stops the write pump.
+// It reports false if the client had already been closed on purpose.
+func (c *Client) detach(limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.detached {
+		return true
+	}
+	if c.closed {
+		return false
+	}
+	c.detached = true
+	c.missedLimit = limit
+drain:
+	for {
+		select {
+		case msg := <-c.send:
+			c.keepMissedLocked(msg)
+		default:
+			break drain
+		}
+	}
+	c.closeLocked()
+	return true
+}
+
+func (c *Client) keepMissedLocked(msg outbound) {
+	if c.missedLimit <= 0 {
+		c.missedDropped++
+		return
+	}
+	if len(c.missed) >= c.missedLimit {
+		c.missed = c.missed[1:]
+		c.missedDropped++
+	}
+	c.missed = append(c.missed, msg)
+}
+
+// takeMissed hands over the frames kept while detached and how many were
+// pushed out. The client accepts no frames afterwards.
+func (c *Client) takeMissed() ([]outbound, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	missed, dropped := c.missed, c.missedDropped
+	c.detached = false
+	c.missed = nil
+	c.missedDropped = 0
+	return missed, dropped
+}
+
+// close closes the send channel exactly once, which stops the write pump.
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+}
+
+func (c *Client) closeLocked() {
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// disconnect closes the connection with the given close code. The session
+// cannot be resumed afterwards.
+func (c *Client) disconnect(code int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closeCode = code
+	c.closeLocked()
+}
+
+// closeMessage returns the close frame the write pump sends on shutdown.
+func (c *Client) closeMessage() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.closeCode {
+	case 0:
+		return []byte{}
+	case CloseSlowConsumer:
+		return websocket.FormatCloseMessage(c.closeCode, "slow consumer")
+	case CloseRateLimited:
+		return websocket.FormatCloseMessage(c.closeCode, "rate limited")
+	default:
+		return websocket.FormatCloseMessage(c.closeCode, "")
+	}
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -29,19 +237,39 @@ func (c *Client) readPump() {
 		c.hub.unregister <- c
 		c.conn.Close()
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
+	maxFrameSize := c.hub.frames.MaxFrameSize
+	c.conn.SetReadLimit(int64(maxFrameSize))
 	c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error { c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 
 	for {
-		var msg IncomingMessage
-		err := c.conn.ReadJSON(&msg)
+		_, r, err := c.conn.NextReader()
+		var data []byte
+		if err == nil {
+			data, err = readFrame(r, maxFrameSize)
+		}
+		if errors.Is(err, errFrameTooLarge) {
+			// Close the way the read limit does for uncompressed frames.
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(writeWait))
+			break
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
+		var msg IncomingMessage
+		if err := c.codec.Unmarshal(data, &msg); err != nil {
+			c.enqueue(errorMessage("", &Error{Code: CodeInvalidPayload, Message: err.Error()}))
+			continue
+		}
+		if limit := c.hub.frames.limit(msg.Type); len(msg.Payload) > limit {
+			reply := errorMessage(msg.Type, tooLarge(msg.Type, limit))
+			reply.ID = msg.ID
+			c.enqueue(reply)
+			continue
+		}
 		// Attach client to the message for the hub to know the sender
 		c.hub.handleMessage(c, msg)
 	}
@@ -60,13 +288,20 @@ func (c *Client) writePump() {
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				c.conn.WriteMessage(websocket.CloseMessage, c.closeMessage())
 				return
 			}
-			if err := c.conn.WriteJSON(message); err != nil {
-				log.Printf("error writing json: %v", err)
+			if err := c.write(message); err != nil {
+				log.Printf("error writing message: %v", err)
 				return
 			}
+			if n := c.takeDropped(); n > 0 {
+				notice := OutgoingMessage{Type: TypeMessagesDropped, Payload: MessagesDroppedPayload{Count: n}}
+				if err := c.write(outbound{msg: notice}); err != nil {
+					log.Printf("error writing message: %v", err)
+					return
+				}
+			}
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
@@ -76,24 +311,93 @@ func (c *Client) writePump() {
 	}
 }
 
+// write writes a single queued message to the connection, compressing it
+// if compression was negotiated and the frame is large enough.
+func (c *Client) write(msg outbound) error {
+	var (
+		data    []byte
+		encoded encodedFrame
+		size    int
+	)
+	if msg.shared == nil {
+		var err error
+		if data, err = c.codec.Marshal(msg.msg); err != nil {
+			return err
+		}
+		size = len(data)
+	} else {
+		var err error
+		if encoded, err = msg.shared.prepare(c.codec); err != nil {
+			// The frame cannot be encoded for anyone, so skip it rather than
+			// dropping the connection.
+			log.Printf("error encoding %s: %v", msg.shared.msg.Type, err)
+			return nil
+		}
+		size = encoded.size
+	}
+
+	compressed := c.compress && size >= c.compressThreshold
+	if c.compress {
+		c.conn.EnableWriteCompression(compressed)
+	}
+	var before uint64
+	if c.wire != nil {
+		before = c.wire.written.Load()
+	}
+
+	var err error
+	if encoded.prepared != nil {
+		err = c.conn.WritePreparedMessage(encoded.prepared)
+	} else {
+		err = c.conn.WriteMessage(c.codec.MessageType(), data)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.wire != nil {
+		c.hub.compressionCounters.record(compressed, size, c.wire.written.Load()-before)
+	}
+	return nil
+}
+
 // ServeWs handles websocket requests from the peer.
 func ServeWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	upgrader := websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		ReadBufferSize:    hub.buffers.ReadBufferSize,
+		WriteBufferSize:   hub.buffers.WriteBufferSize,
+		Subprotocols:      subprotocols(),
+		EnableCompression: hub.compression.Enabled,
 		CheckOrigin: func(r *http.Request) bool {
 			// Allow all connections for development
 			return true
 		},
 	}
 
-	conn, err := upgrader.Upgrade(w, r, nil)
+	counting := &countingResponseWriter{ResponseWriter: w}
+	conn, err := upgrader.Upgrade(counting, r, nil)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	client := &Client{hub: hub, conn: conn, send: make(chan OutgoingMessage, 256)}
+	compress := hub.compression.Enabled && offersDeflate(r)
+	if compress {
+		if err := conn.SetCompressionLevel(hub.compression.Level); err != nil {
+			log.Printf("invalid compression level: %v", err)
+		}
+	}
+
+	policy := hub.sendPolicy(r.URL.Query().Get("class"))
+	bufferSize := policy.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultSendPolicy.BufferSize
+	}
+	client := &Client{hub: hub, conn: conn, send: make(chan outbound, bufferSize), policy: policy}
+	client.codec = codecFor(conn.Subprotocol())
+	client.wire = counting.conn
+	client.compress = compress
+	client.compressThreshold = hub.compression.Threshold
 	client.hub.register <- client
 
 	// Allow collection of memory referenced by the caller by doing all work in
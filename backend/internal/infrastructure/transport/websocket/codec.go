@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket subprotocols, one per codec. A client that does not ask for a
+// subprotocol speaks JSON.
+const (
+	SubprotocolJSON    = "chat.json"
+	SubprotocolMsgpack = "chat.msgpack"
+)
+
+// Codec encodes and decodes frames for one wire format. Decoding is strict
+// and rejects unknown fields.
+type Codec interface {
+	Subprotocol() string
+	MessageType() int // websocket.TextMessage or websocket.BinaryMessage
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// codecs lists the supported codecs in order of server preference.
+var codecs = []Codec{msgpackCodec{}, jsonCodec{}}
+
+// codecFor returns the codec for a negotiated subprotocol.
+func codecFor(subprotocol string) Codec {
+	for _, codec := range codecs {
+		if codec.Subprotocol() == subprotocol {
+			return codec
+		}
+	}
+	return jsonCodec{}
+}
+
+func subprotocols() []string {
+	names := make([]string, len(codecs))
+	for i, codec := range codecs {
+		names[i] = codec.Subprotocol()
+	}
+	return names
+}
+
+// jsonCodec is the text codec. Byte slices such as ciphertext travel as
+// base64 strings.
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+func (jsonCodec) MessageType() int    { return websocket.TextMessage }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return decodeStrict(data, v)
+}
+
+// msgpackCodec is the compact binary codec. It reuses the json struct tags,
+// and byte slices such as ciphertext travel as raw bytes.
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+func (msgpackCodec) MessageType() int    { return websocket.BinaryMessage }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	reader := bytes.NewReader(data)
+	dec := msgpack.NewDecoder(reader)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if reader.Len() > 0 {
+		return errors.New("unexpected data after payload")
+	}
+	return nil
+}
+
+// RawPayload is a payload that has not been decoded yet, kept in the wire
+// format of the connection it arrived on.
+type RawPayload []byte
+
+func (p RawPayload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p *RawPayload) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+func (p RawPayload) EncodeMsgpack(enc *msgpack.Encoder) error {
+	if len(p) == 0 {
+		return enc.EncodeNil()
+	}
+	return enc.Encode(msgpack.RawMessage(p))
+}
+
+func (p *RawPayload) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	if err != nil {
+		return err
+	}
+	*p = RawPayload(raw)
+	return nil
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestCodecNegotiation(t *testing.T) {
+	tests := []struct {
+		name    string
+		offered []string
+		want    string
+	}{
+		{name: "none", want: ""},
+		{name: "json", offered: []string{SubprotocolJSON}, want: SubprotocolJSON},
+		{name: "msgpack", offered: []string{SubprotocolMsgpack}, want: SubprotocolMsgpack},
+		{name: "server prefers msgpack", offered: []string{SubprotocolJSON, SubprotocolMsgpack}, want: SubprotocolMsgpack},
+		{name: "unknown", offered: []string{"chat.cbor"}, want: ""},
+	}
+	srv := newTestServer(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := srv.dial(tt.offered...)
+			if got := c.conn.Subprotocol(); got != tt.want {
+				t.Fatalf("negotiated %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMsgpackCiphertext checks that ciphertext crosses between a msgpack and
+// a JSON connection unchanged, and travels over msgpack as raw bytes.
+func TestMsgpackCiphertext(t *testing.T) {
+	srv := newTestServer(t)
+	alice := srv.login(srv.dial(SubprotocolMsgpack), AuthenticatePayload{DisplayName: "alice", PublicKey: "key-alice"})
+	bob := srv.connect("bob")
+	groupID := alice.createGroup("group")
+	bob.join(groupID)
+
+	ciphertext := make([]byte, 256)
+	for i := range ciphertext {
+		ciphertext[i] = byte(i)
+	}
+	alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: ciphertext})
+	var msg NewMessagePayload
+	bob.expect(TypeNewMessage).decode(bob, &msg)
+	if !bytes.Equal(msg.Ciphertext, ciphertext) {
+		t.Fatalf("JSON client got %x, want %x", msg.Ciphertext, ciphertext)
+	}
+
+	bob.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: ciphertext})
+	for {
+		alice.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		kind, data, err := alice.conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no frame: %v", err)
+		}
+		if kind != websocket.BinaryMessage {
+			t.Fatalf("msgpack connection got a frame of kind %d", kind)
+		}
+		var frame struct {
+			Type    string                 `msgpack:"type"`
+			Payload map[string]interface{} `msgpack:"payload"`
+		}
+		if err := msgpack.Unmarshal(data, &frame); err != nil {
+			t.Fatal(err)
+		}
+		if frame.Type != TypeNewMessage {
+			continue
+		}
+		if got, ok := frame.Payload["ciphertext"].([]byte); !ok || !bytes.Equal(got, ciphertext) {
+			t.Fatalf("ciphertext arrived as %T %v, want the raw bytes", frame.Payload["ciphertext"], frame.Payload["ciphertext"])
+		}
+		return
+	}
+}
+
+// TestMsgpackRejectsUnknownFields checks that the binary codec is as strict
+// as the JSON one.
+func TestMsgpackRejectsUnknownFields(t *testing.T) {
+	srv := newTestServer(t)
+	c := srv.dial(SubprotocolMsgpack)
+	c.send(TypeAuthenticate, map[string]string{"displayName": "alice", "publicKey": "key", "role": "admin"})
+	if got := c.expectError(); got.Code != CodeInvalidPayload {
+		t.Fatalf("got %s (%s), want %s", got.Code, got.Message, CodeInvalidPayload)
+	}
+}
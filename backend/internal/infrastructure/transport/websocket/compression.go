@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// CompressionConfig configures permessage-deflate (RFC 7692). Compression is
+// only used on connections whose client offers the extension, and only for
+// frames of at least Threshold bytes, since deflating tiny frames costs more
+// CPU than it saves bandwidth.
+type CompressionConfig struct {
+	Enabled   bool
+	Level     int // flate level, from flate.HuffmanOnly to flate.BestCompression
+	Threshold int // Minimum encoded frame size in bytes to compress
+}
+
+// DefaultCompressionConfig leaves compression off.
+var DefaultCompressionConfig = CompressionConfig{
+	Enabled:   false,
+	Level:     flate.BestSpeed,
+	Threshold: 1024,
+}
+
+// BufferConfig sets the per-connection I/O buffer sizes in bytes.
+type BufferConfig struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+// DefaultBufferConfig matches the upgrader's historical fixed buffers.
+var DefaultBufferConfig = BufferConfig{ReadBufferSize: 1024, WriteBufferSize: 1024}
+
+// WithCompression enables or tunes permessage-deflate.
+func WithCompression(config CompressionConfig) Option {
+	return func(h *Hub) { h.compression = config }
+}
+
+// WithBufferSizes sets the read and write buffer sizes of new connections.
+func WithBufferSizes(config BufferConfig) Option {
+	return func(h *Hub) { h.buffers = config }
+}
+
+// CompressionStats shows what compression saves. Compressed frames are
+// counted before compression and as written to the network, framing included,
+// so the two byte counts can be compared directly.
+type CompressionStats struct {
+	CompressedFrames   uint64 `json:"compressedFrames"`
+	BytesBefore        uint64 `json:"bytesBeforeCompression"`
+	BytesAfter         uint64 `json:"bytesAfterCompression"`
+	UncompressedFrames uint64 `json:"uncompressedFrames"`
+	UncompressedBytes  uint64 `json:"uncompressedBytes"`
+}
+
+type compressionCounters struct {
+	compressedFrames   atomic.Uint64
+	bytesBefore        atomic.Uint64
+	bytesAfter         atomic.Uint64
+	uncompressedFrames atomic.Uint64
+	uncompressedBytes  atomic.Uint64
+}
+
+func (c *compressionCounters) record(compressed bool, size int, written uint64) {
+	if compressed {
+		c.compressedFrames.Add(1)
+		c.bytesBefore.Add(uint64(size))
+		c.bytesAfter.Add(written)
+		return
+	}
+	c.uncompressedFrames.Add(1)
+	c.uncompressedBytes.Add(written)
+}
+
+func (c *compressionCounters) snapshot() CompressionStats {
+	return CompressionStats{
+		CompressedFrames:   c.compressedFrames.Load(),
+		BytesBefore:        c.bytesBefore.Load(),
+		BytesAfter:         c.bytesAfter.Load(),
+		UncompressedFrames: c.uncompressedFrames.Load(),
+		UncompressedBytes:  c.uncompressedBytes.Load(),
+	}
+}
+
+// CompressionStats returns compression byte counts since startup.
+func (h *Hub) CompressionStats() CompressionStats {
+	return h.compressionCounters.snapshot()
+}
+
+// offersDeflate reports whether the client asked for permessage-deflate.
+func offersDeflate(r *http.Request) bool {
+	for _, value := range r.Header.Values("Sec-WebSocket-Extensions") {
+		if strings.Contains(value, "permessage-deflate") {
+			return true
+		}
+	}
+	return false
+}
+
+// countingConn counts the bytes written to the network.
+type countingConn struct {
+	net.Conn
+	written atomic.Uint64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(uint64(n))
+	return n, err
+}
+
+// countingResponseWriter hands the upgrader a countingConn when it hijacks
+// the connection.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (w *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.conn = &countingConn{Conn: conn}
+	return w.conn, rw, nil
+}
@@ -0,0 +1,96 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+const sendDedupeWindow = 5 * time.Minute
+
+// WithSendDedupeWindow sets how long a client message ID is remembered, so
+// that a retried send_message within that time is not broadcast again.
+func WithSendDedupeWindow(d time.Duration) Option {
+	return func(h *Hub) { h.sendDedupeWindow = d }
+}
+
+// sendDeduper remembers the client message IDs each sender has used
+// recently, together with the sequence number and message ID the message was
+// given.
+type sendDeduper struct {
+	clock  clock.Clock
+	window time.Duration
+	sent   map[sendKey]*sentMessage
+	order  []sendKey // Oldest first; every entry lives for the same window
+	mu     sync.Mutex
+}
+
+type sendKey struct {
+	userID          string
+	clientMessageID string
+}
+
+type sentMessage struct {
+	groupID   string
+	seq       uint64
+	messageID string
+	err       error // Set instead of seq when the send was rejected
+	expires   time.Time
+	done      chan struct{} // Closed once the outcome is set
+}
+
+func newSendDeduper(c clock.Clock, window time.Duration) *sendDeduper {
+	return &sendDeduper{
+		clock:  c,
+		window: window,
+		sent:   make(map[sendKey]*sentMessage),
+	}
+}
+
+// claim records a client message ID for the sender. It reports true when the
+// ID is new, in which case the caller sends the message and then calls
+// finish. Otherwise it returns the earlier send, after waiting for it to
+// finish if it is still in flight.
+func (d *sendDeduper) claim(userID, clientMessageID, groupID string) (*sentMessage, bool) {
+	d.mu.Lock()
+	now := d.clock.Now()
+	d.pruneLocked(now)
+	key := sendKey{userID, clientMessageID}
+	if sent, ok := d.sent[key]; ok {
+		d.mu.Unlock()
+		<-sent.done
+		return sent, false
+	}
+	sent := &sentMessage{groupID: groupID, expires: now.Add(d.window), done: make(chan struct{})}
+	d.sent[key] = sent
+	d.order = append(d.order, key)
+	d.mu.Unlock()
+	return sent, true
+}
+
+// finish records the sequence number and message ID of a claimed message
+// and releases any retries waiting for it.
+func (d *sendDeduper) finish(sent *sentMessage, seq uint64, messageID string) {
+	sent.seq = seq
+	sent.messageID = messageID
+	close(sent.done)
+}
+
+// fail records that a claimed message was rejected. Retries within the
+// window get the same error.
+func (d *sendDeduper) fail(sent *sentMessage, err error) {
+	sent.err = err
+	close(sent.done)
+}
+
+func (d *sendDeduper) pruneLocked(now time.Time) {
+	for len(d.order) > 0 {
+		key := d.order[0]
+		if d.sent[key].expires.After(now) {
+			return
+		}
+		delete(d.sent, key)
+		d.order = d.order[1:]
+	}
+}
@@ -0,0 +1,108 @@
+package websocket
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+func TestSendDeduperClaim(t *testing.T) {
+	errRejected := errors.New("rejected")
+	type claim struct {
+		after           time.Duration // Advance the clock by this much first
+		userID          string
+		clientMessageID string
+		wantNew         bool
+		wantSeq         uint64 // For a repeat, the outcome of the earlier send
+		wantErr         error
+		reject          bool // Reject the send instead of finishing it
+	}
+	tests := []struct {
+		name   string
+		claims []claim
+	}{
+		{
+			name: "repeat within the window",
+			claims: []claim{
+				{userID: "alice", clientMessageID: "c1", wantNew: true},
+				{after: time.Minute, userID: "alice", clientMessageID: "c1", wantSeq: 1},
+			},
+		},
+		{
+			name: "repeat after the window",
+			claims: []claim{
+				{userID: "alice", clientMessageID: "c1", wantNew: true},
+				{after: sendDedupeWindow, userID: "alice", clientMessageID: "c1", wantNew: true},
+			},
+		},
+		{
+			name: "IDs are per sender",
+			claims: []claim{
+				{userID: "alice", clientMessageID: "c1", wantNew: true},
+				{userID: "bob", clientMessageID: "c1", wantNew: true},
+				{userID: "alice", clientMessageID: "c2", wantNew: true},
+				{userID: "bob", clientMessageID: "c1", wantSeq: 2},
+			},
+		},
+		{
+			name: "repeat of a rejected send",
+			claims: []claim{
+				{userID: "alice", clientMessageID: "c1", wantNew: true, reject: true},
+				{userID: "alice", clientMessageID: "c1", wantErr: errRejected},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := clock.NewFake(time.Unix(1700000000, 0))
+			d := newSendDeduper(fake, sendDedupeWindow)
+			seq := uint64(0)
+			for i, c := range tt.claims {
+				fake.Advance(c.after)
+				sent, isNew := d.claim(c.userID, c.clientMessageID, "group")
+				if isNew != c.wantNew {
+					t.Fatalf("claim %d: new = %v, want %v", i, isNew, c.wantNew)
+				}
+				if !isNew {
+					if sent.seq != c.wantSeq || sent.err != c.wantErr {
+						t.Fatalf("claim %d: got seq %d, err %v; want seq %d, err %v", i, sent.seq, sent.err, c.wantSeq, c.wantErr)
+					}
+					continue
+				}
+				if c.reject {
+					d.fail(sent, errRejected)
+					continue
+				}
+				seq++
+				d.finish(sent, seq, "m")
+			}
+		})
+	}
+}
+
+// TestSendDeduperClaimWaits checks that a retry of a send still in flight
+// waits for its outcome instead of sending again.
+func TestSendDeduperClaimWaits(t *testing.T) {
+	d := newSendDeduper(clock.NewFake(time.Unix(1700000000, 0)), sendDedupeWindow)
+	first, _ := d.claim("alice", "c1", "group")
+
+	done := make(chan *sentMessage)
+	go func() {
+		sent, isNew := d.claim("alice", "c1", "group")
+		if isNew {
+			t.Error("retry claimed a new send")
+		}
+		done <- sent
+	}()
+	select {
+	case <-done:
+		t.Fatal("retry returned before the first send finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	d.finish(first, 7, "m")
+	if sent := <-done; sent.seq != 7 {
+		t.Fatalf("retry got seq %d, want 7", sent.seq)
+	}
+}
@@ -0,0 +1,34 @@
+package websocket
+
+import (
+	"context"
+)
+
+// openDirect returns the ID of the direct conversation between the user and
+// the peer, opening it on first use. Users who were not members yet are
+// subscribed, start counting receipts from the latest message and receive a
+// direct_opened; a peer who is offline keeps the conversation in their
+// mailbox memberships. Later sends leave the members alone, whether they
+// are online or not.
+func (h *Hub) openDirect(ctx context.Context, userID, peerID string) (string, error) {
+	if peerID == userID {
+		return "", invalidPayload("cannot open a direct conversation with yourself")
+	}
+	group, added, err := h.chatService.OpenDirectConversation(ctx, userID, peerID)
+	if err != nil {
+		return "", err
+	}
+	h.cleanup.cancel(group.ID)
+	if len(added) == 0 {
+		return group.ID, nil
+	}
+
+	view := h.groupView(group)
+	for _, id := range added {
+		h.subscribeUser(group.ID, id)
+		h.joinOffline(id, group.ID)
+		h.groupLog(group.ID).receipts.join(id, view.LastSeq)
+		h.sendToUser(id, OutgoingMessage{Type: TypeDirectOpened, Payload: view}, nil)
+	}
+	return group.ID, nil
+}
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"testing"
+)
+
+// TestDirectToOfflinePeer checks that sending to an offline peer opens the
+// conversation once, so that the peer's receipts count every message they
+// were sent.
+func TestDirectToOfflinePeer(t *testing.T) {
+	srv := newTestServer(t, testMailbox(), WithResume(ResumeConfig{}))
+	alice, bob := srv.connect("alice"), srv.connect("bob")
+	srv.goOffline(bob)
+
+	var groupID string
+	for i := 0; i < 3; i++ {
+		alice.send(TypeSendMessage, SendMessagePayload{RecipientID: bob.userID, Ciphertext: []byte("hi")})
+		if i == 0 {
+			var view GroupView
+			alice.expect(TypeDirectOpened).decode(alice, &view)
+			groupID = view.ID
+		}
+		frame := alice.next()
+		if frame.Type != TypeAck {
+			t.Fatalf("send %d got %s, want only an ack", i, frame.Type)
+		}
+	}
+
+	bob = srv.reconnect(bob.token)
+	for i := 0; i < 3; i++ {
+		bob.expect(TypeMailboxMessage)
+	}
+	bob.send(TypeRead, ReceiptPayload{GroupID: groupID, Seq: 3})
+	bob.expect(TypeAck)
+	srv.clock.Advance(receiptFlushInterval)
+
+	var receipts ReceiptsUpdatedPayload
+	alice.expect(TypeReceiptsUpdated).decode(alice, &receipts)
+	if len(receipts.Messages) != 3 {
+		t.Fatalf("receipts for %d messages, want 3", len(receipts.Messages))
+	}
+	for _, c := range receipts.Messages {
+		if c.Delivered != 1 || c.Read != 1 {
+			t.Fatalf("message %d delivered to %d and read by %d, want 1 and 1", c.Seq, c.Delivered, c.Read)
+		}
+	}
+}
+
+// TestDirectReopened checks that a member who left a direct conversation is
+// added back by the next message and told so.
+func TestDirectReopened(t *testing.T) {
+	srv := newTestServer(t)
+	alice, bob := srv.connect("alice"), srv.connect("bob")
+	ack := alice.sendMessage(SendMessagePayload{RecipientID: bob.userID, Ciphertext: []byte("hi")})
+	var view GroupView
+	bob.expect(TypeDirectOpened).decode(bob, &view)
+	bob.expect(TypeNewMessage)
+
+	bob.send(TypeLeaveGroup, LeaveGroupPayload{GroupID: view.ID})
+	bob.expect(TypeGroupLeft)
+	alice.expect(TypeMemberLeft)
+
+	alice.sendMessage(SendMessagePayload{RecipientID: bob.userID, Ciphertext: []byte("back?")})
+	bob.expect(TypeDirectOpened).decode(bob, &view)
+	if view.LastSeq <= ack.Seq {
+		t.Fatalf("reopened at seq %d, want past %d", view.LastSeq, ack.Seq)
+	}
+	var msg NewMessagePayload
+	bob.expect(TypeNewMessage).decode(bob, &msg)
+	if string(msg.Ciphertext) != "back?" {
+		t.Fatalf("got %q, want the message sent after reopening", msg.Ciphertext)
+	}
+}
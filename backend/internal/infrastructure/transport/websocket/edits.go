@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"context"
+)
+
+var errMessageNotFound = &Error{Code: CodeMessageNotFound, Message: "message not found or no longer retained"}
+
+// handleEditMessage replaces the ciphertext of one of the sender's own
+// messages. Only messages still in the group's history can be edited.
+func (h *Hub) handleEditMessage(ctx context.Context, req *Request) error {
+	body := req.Body.(*EditMessagePayload)
+	if body.GroupID == "" || body.MessageID == "" || len(body.Ciphertext) == 0 {
+		return invalidPayload("groupId, messageId and ciphertext are required")
+	}
+	if !h.isSubscribed(body.GroupID, req.Client) {
+		return errNotSubscribed
+	}
+
+	seq, err := h.reviseMessage(body.GroupID, body.MessageID, func(e *envelope) (interface{}, []byte, error) {
+		if e.senderID != req.UserID {
+			return nil, nil, &Error{Code: CodeForbidden, Message: "only the sender can edit a message"}
+		}
+		e.edited = true
+		return MessageEditedPayload{
+			GroupID:    body.GroupID,
+			MessageID:  body.MessageID,
+			MessageSeq: e.seq,
+			SenderID:   e.senderID,
+			Ciphertext: body.Ciphertext,
+		}, body.Ciphertext, nil
+	})
+	if err != nil {
+		return err
+	}
+	req.AckWith(AckPayload{Seq: seq})
+	return nil
+}
+
+// handleDeleteMessage retracts a message on behalf of its sender or a group
+// moderator. The ciphertext is dropped from history, from the frames kept
+// for sync and from offline mailboxes; a tombstone remains in history so
+// that members who already have the message learn it is gone.
+func (h *Hub) handleDeleteMessage(ctx context.Context, req *Request) error {
+	body := req.Body.(*DeleteMessagePayload)
+	if body.GroupID == "" || body.MessageID == "" {
+		return invalidPayload("groupId and messageId are required")
+	}
+	if !h.isSubscribed(body.GroupID, req.Client) {
+		return errNotSubscribed
+	}
+	group, err := h.chatService.GetGroup(ctx, body.GroupID)
+	if err != nil {
+		return err
+	}
+	moderator := group.IsModerator(req.UserID)
+
+	seq, err := h.reviseMessage(body.GroupID, body.MessageID, func(e *envelope) (interface{}, []byte, error) {
+		if e.senderID != req.UserID && !moderator {
+			return nil, nil, &Error{Code: CodeForbidden, Message: "only the sender or a moderator can delete a message"}
+		}
+		e.deleted, e.edited, e.reactions = true, false, nil
+		return MessageDeletedPayload{
+			GroupID:    body.GroupID,
+			MessageID:  body.MessageID,
+			MessageSeq: e.seq,
+			DeletedBy:  req.UserID,
+		}, nil, nil
+	})
+	if err != nil {
+		return err
+	}
+	req.AckWith(AckPayload{Seq: seq})
+	return nil
+}
+
+// reviseMessage changes a message in the group's history and broadcasts the
+// change as the group's next frame. change vets the request against the
+// message, marks it and returns the event payload together with the new
+// ciphertext, which is nil for a delete. The same change is applied to the
+// frames kept for sync and to the mailbox copies of the message: the
+// original frame carries the message as it now stands, and earlier edits
+// become copies of this change, so that no frame serves replaced or deleted
+// ciphertext.
+func (h *Hub) reviseMessage(groupID, messageID string, change func(*envelope) (interface{}, []byte, error)) (uint64, error) {
+	gl := h.groupLog(groupID)
+	defer gl.flushMailbox()
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	now := h.clock.Now()
+	gl.history.trim(h.history, now)
+	e := gl.history.find(messageID)
+	if e == nil || e.deleted {
+		return 0, errMessageNotFound
+	}
+	event, ciphertext, err := change(e)
+	if err != nil {
+		return 0, err
+	}
+	msgType := TypeMessageEdited
+	if ciphertext == nil {
+		msgType = TypeMessageDeleted
+	}
+	gl.history.setCiphertext(e, ciphertext)
+	gl.replace(e.seq, TypeNewMessage, e.payload(groupID))
+	for len(e.edits) > 0 && e.edits[0] < gl.oldestSeq() {
+		e.edits = e.edits[1:]
+	}
+	for _, seq := range e.edits {
+		gl.replace(seq, msgType, event)
+	}
+
+	frame := gl.stamp(OutgoingMessage{Type: msgType, Payload: event}, h.history, now)
+	if ciphertext == nil {
+		e.edits = nil
+	} else {
+		e.edits = append(e.edits, frame.msg.Seq)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.fanOutLocked(groupID, frame, func(*Client) bool { return true })
+	h.reviseMailboxLocked(gl, e, ciphertext)
+	return frame.msg.Seq, nil
+}
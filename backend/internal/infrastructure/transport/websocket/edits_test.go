@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"testing"
+)
+
+// TestRevisionsRewriteSyncFrames checks that a sync after an edit or delete
+// only serves the message as it now stands, including the frames of earlier
+// edits.
+func TestRevisionsRewriteSyncFrames(t *testing.T) {
+	type revision struct {
+		ciphertext string // Empty for a delete
+	}
+	tests := []struct {
+		name      string
+		revisions []revision
+		wantTypes []string // For the frames after the new_message
+		want      string   // Ciphertext every frame carries, empty once deleted
+	}{
+		{
+			name:      "edits",
+			revisions: []revision{{"v2"}, {"v3"}},
+			wantTypes: []string{TypeMessageEdited, TypeMessageEdited},
+			want:      "v3",
+		},
+		{
+			name:      "delete after an edit",
+			revisions: []revision{{"SECRET-v2"}, {""}},
+			wantTypes: []string{TypeMessageDeleted, TypeMessageDeleted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			alice := srv.connect("alice")
+			groupID := alice.createGroup("group")
+			ack := alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("v1")})
+			for _, r := range tt.revisions {
+				if r.ciphertext == "" {
+					alice.send(TypeDeleteMessage, DeleteMessagePayload{GroupID: groupID, MessageID: ack.MessageID})
+				} else {
+					alice.send(TypeEditMessage, EditMessagePayload{GroupID: groupID, MessageID: ack.MessageID, Ciphertext: []byte(r.ciphertext)})
+				}
+				alice.expect(TypeAck)
+			}
+
+			carol := srv.connect("carol")
+			carol.join(groupID)
+			carol.send(TypeSyncRequest, SyncRequestPayload{GroupID: groupID, FromSeq: ack.Seq})
+
+			var msg NewMessagePayload
+			carol.expect(TypeNewMessage).decode(carol, &msg)
+			if string(msg.Ciphertext) != tt.want || msg.Deleted != (tt.want == "") {
+				t.Fatalf("new_message carries %q, deleted %v; want %q", msg.Ciphertext, msg.Deleted, tt.want)
+			}
+			for i, wantType := range tt.wantTypes {
+				frame := carol.next()
+				if frame.Type != wantType || frame.Seq != ack.Seq+uint64(i)+1 {
+					t.Fatalf("frame %d is %s with seq %d, want %s with seq %d", i, frame.Type, frame.Seq, wantType, ack.Seq+uint64(i)+1)
+				}
+				if frame.Type == TypeMessageEdited {
+					var edit MessageEditedPayload
+					frame.decode(carol, &edit)
+					if string(edit.Ciphertext) != tt.want {
+						t.Fatalf("message_edited with seq %d carries %q, want %q", frame.Seq, edit.Ciphertext, tt.want)
+					}
+				}
+			}
+			carol.expect(TypeSyncResponse)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package websocket
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// outbound is a message queued on a client's send channel. Direct replies
+// carry the message itself and are encoded by the recipient's write pump.
+// Broadcasts carry a sharedFrame so they are encoded once for all recipients.
+// Transient frames only matter right now and are not kept for a resume.
+type outbound struct {
+	msg       OutgoingMessage
+	shared    *sharedFrame
+	transient bool
+}
+
+// sharedFrame is an immutable message that is encoded at most once per codec,
+// on first write, and then written to every connection it was queued on.
+type sharedFrame struct {
+	msg      OutgoingMessage
+	mu       sync.Mutex
+	encoded  map[Codec]encodedFrame
+	failures map[Codec]error
+}
+
+type encodedFrame struct {
+	prepared *websocket.PreparedMessage
+	size     int // Encoded size before any compression
+}
+
+func newSharedFrame(msg OutgoingMessage) *sharedFrame {
+	return &sharedFrame{msg: msg}
+}
+
+// prepare returns the frame encoded with the codec, encoding it on first use.
+func (f *sharedFrame) prepare(codec Codec) (encodedFrame, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if encoded, ok := f.encoded[codec]; ok {
+		return encoded, nil
+	}
+	if err, ok := f.failures[codec]; ok {
+		return encodedFrame{}, err
+	}
+
+	encoded, err := f.encode(codec)
+	if err != nil {
+		if f.failures == nil {
+			f.failures = make(map[Codec]error)
+		}
+		f.failures[codec] = err
+		return encodedFrame{}, err
+	}
+	if f.encoded == nil {
+		f.encoded = make(map[Codec]encodedFrame, 1)
+	}
+	f.encoded[codec] = encoded
+	return encoded, nil
+}
+
+func (f *sharedFrame) encode(codec Codec) (encodedFrame, error) {
+	data, err := codec.Marshal(f.msg)
+	if err != nil {
+		return encodedFrame{}, err
+	}
+	prepared, err := websocket.NewPreparedMessage(codec.MessageType(), data)
+	if err != nil {
+		return encodedFrame{}, err
+	}
+	return encodedFrame{prepared: prepared, size: len(data)}, nil
+}
@@ -0,0 +1,251 @@
+package websocket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// groupRetention is how many recent broadcasts each group keeps for
+	// clients that ask to fill a gap. The ciphertext they carry and their
+	// age are bounded like the group's history.
+	groupRetention = 256
+	// maxSyncFrames caps the frames served by a single sync_request.
+	maxSyncFrames = 100
+)
+
+// groupLog orders the broadcasts of one group. Each broadcast is stamped with
+// the next sequence number, and the most recent ones are retained so that a
+// client that notices a gap can ask for what it missed.
+type groupLog struct {
+	mu            sync.Mutex
+	seq           uint64         // Sequence number of the latest broadcast
+	retained      []*sharedFrame // Oldest first, with consecutive sequence numbers
+	retainedBytes int            // Ciphertext carried by the retained frames
+	history       messageHistory
+	receipts      receiptTracker
+
+	mailboxOps []mailboxOp // Mailbox writes waiting to run, in broadcast order
+	mailboxMu  sync.Mutex  // Held while running mailboxOps; taken before mu, never after
+}
+
+// mailboxOp is a mailbox write queued under the log's lock. The writes reach
+// the repository outside every hub lock, but in the order they were queued.
+type mailboxOp func(ctx context.Context)
+
+// queueMailbox queues a mailbox write. The caller holds l.mu and runs
+// flushMailbox once it has released every lock.
+func (l *groupLog) queueMailbox(op mailboxOp) {
+	l.mailboxOps = append(l.mailboxOps, op)
+}
+
+// flushMailbox runs the queued mailbox writes, including any queued by
+// others while it runs. It returns once everything queued before the call
+// has been written.
+func (l *groupLog) flushMailbox() {
+	l.mailboxMu.Lock()
+	defer l.mailboxMu.Unlock()
+	ctx := context.Background()
+	for {
+		l.mu.Lock()
+		ops := l.mailboxOps
+		l.mailboxOps = nil
+		l.mu.Unlock()
+		if len(ops) == 0 {
+			return
+		}
+		for _, op := range ops {
+			op(ctx)
+		}
+	}
+}
+
+// retain keeps a frame for sync and drops the oldest ones beyond the bounds.
+func (l *groupLog) retain(frame *sharedFrame, config HistoryConfig, now time.Time) {
+	l.retained = append(l.retained, frame)
+	l.retainedBytes += frameCiphertext(frame)
+	l.trimRetained(config, now)
+}
+
+// trimRetained drops retained frames from the front until there are at most
+// groupRetention, and the ciphertext they carry and their age are within the
+// bounds of the group's history, so that a sync serves nothing history has
+// already dropped.
+func (l *groupLog) trimRetained(config HistoryConfig, now time.Time) {
+	cutoff := now.Add(-config.MaxAge).UnixMilli()
+	n := 0
+	for ; n < len(l.retained); n++ {
+		frame := l.retained[n]
+		over := len(l.retained)-n > groupRetention ||
+			(config.MaxBytes > 0 && l.retainedBytes > config.MaxBytes) ||
+			(config.MaxAge > 0 && frame.msg.Timestamp < cutoff)
+		if !over {
+			break
+		}
+		l.retainedBytes -= frameCiphertext(frame)
+		l.retained[n] = nil
+	}
+	l.retained = l.retained[n:]
+}
+
+// frameCiphertext returns the size of the ciphertext a frame carries, which
+// is what makes up most of a retained frame.
+func frameCiphertext(frame *sharedFrame) int {
+	switch payload := frame.msg.Payload.(type) {
+	case NewMessagePayload:
+		return len(payload.Ciphertext)
+	case MessageEditedPayload:
+		return len(payload.Ciphertext)
+	}
+	return 0
+}
+
+// oldestSeq returns the sequence number of the oldest retained frame, or the
+// next sequence number if nothing is retained.
+func (l *groupLog) oldestSeq() uint64 {
+	if len(l.retained) == 0 {
+		return l.seq + 1
+	}
+	return l.retained[0].msg.Seq
+}
+
+// between returns the retained frames with from <= seq <= to, at most limit.
+func (l *groupLog) between(from, to uint64, limit int) []*sharedFrame {
+	var frames []*sharedFrame
+	for _, frame := range l.retained {
+		seq := frame.msg.Seq
+		if seq < from {
+			continue
+		}
+		if seq > to || len(frames) == limit {
+			break
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// replace swaps the retained frame with the given sequence number for one
+// with a new type and payload, so that a sync no longer serves the old
+// contents. Frames already queued for delivery are not affected.
+func (l *groupLog) replace(seq uint64, msgType string, payload interface{}) {
+	oldest := l.oldestSeq()
+	if seq < oldest || seq > l.seq {
+		return
+	}
+	msg := l.retained[seq-oldest].msg
+	msg.Type, msg.Payload = msgType, payload
+	frame := newSharedFrame(msg)
+	l.retainedBytes += frameCiphertext(frame) - frameCiphertext(l.retained[seq-oldest])
+	l.retained[seq-oldest] = frame
+}
+
+// groupLog returns the log of a group, creating it on first use.
+func (h *Hub) groupLog(groupID string) *groupLog {
+	h.mu.RLock()
+	gl := h.logs[groupID]
+	h.mu.RUnlock()
+	if gl != nil {
+		return gl
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if gl = h.logs[groupID]; gl == nil {
+		gl = &groupLog{}
+		h.logs[groupID] = gl
+	}
+	return gl
+}
+
+// lastSeq returns the sequence number of the group's latest broadcast.
+func (h *Hub) lastSeq(groupID string) uint64 {
+	gl := h.groupLog(groupID)
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	return gl.seq
+}
+
+// stamp gives msg the next sequence number and the server time, and retains
+// the resulting frame within the bounds of config.
+func (gl *groupLog) stamp(msg OutgoingMessage, config HistoryConfig, now time.Time) *sharedFrame {
+	gl.seq++
+	msg.Seq = gl.seq
+	msg.Timestamp = now.UnixMilli()
+	frame := newSharedFrame(msg)
+	gl.retain(frame, config, now)
+	return frame
+}
+
+// publish stamps msg with the group's next sequence number and the server
+// time, retains it, and queues it for every subscriber that include accepts.
+// The log stays locked during the fan-out, so every subscriber receives the
+// group's frames in sequence order.
+func (h *Hub) publish(groupID string, msg OutgoingMessage, include func(*Client) bool) uint64 {
+	gl := h.groupLog(groupID)
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	frame := gl.stamp(msg, h.history, h.clock.Now())
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.fanOutLocked(groupID, frame, include)
+	return frame.msg.Seq
+}
+
+// fanOutLocked queues frame for every subscriber of the group that include
+// accepts. The caller holds h.mu.
+func (h *Hub) fanOutLocked(groupID string, frame *sharedFrame, include func(*Client) bool) {
+	for member := range h.groups[groupID] {
+		if include(member) {
+			member.enqueueShared(frame)
+		}
+	}
+}
+
+// handleSyncRequest resends a range of a group's broadcasts that the client
+// missed. Frames that are no longer retained are skipped; the closing
+// sync_response tells the client what range could be served.
+func (h *Hub) handleSyncRequest(ctx context.Context, req *Request) error {
+	body := req.Body.(*SyncRequestPayload)
+	if body.GroupID == "" || body.FromSeq == 0 {
+		return invalidPayload("groupId and fromSeq are required")
+	}
+	if body.ToSeq != 0 && body.ToSeq < body.FromSeq {
+		return invalidPayload("toSeq must not be below fromSeq")
+	}
+	if !h.isSubscribed(body.GroupID, req.Client) {
+		return errNotSubscribed
+	}
+
+	gl := h.groupLog(body.GroupID)
+	gl.mu.Lock()
+	gl.trimRetained(h.history, h.clock.Now())
+	to := body.ToSeq
+	if to == 0 || to > gl.seq {
+		to = gl.seq
+	}
+	frames := gl.between(body.FromSeq, to, maxSyncFrames)
+	if len(frames) == maxSyncFrames {
+		to = frames[len(frames)-1].msg.Seq
+	}
+	oldest, last := gl.oldestSeq(), gl.seq
+	gl.mu.Unlock()
+
+	for _, frame := range frames {
+		req.Client.enqueueShared(frame)
+	}
+	req.Reply(OutgoingMessage{
+		Type: TypeSyncResponse,
+		Payload: SyncResponsePayload{
+			GroupID:   body.GroupID,
+			FromSeq:   body.FromSeq,
+			ToSeq:     to,
+			Count:     len(frames),
+			OldestSeq: oldest,
+			LastSeq:   last,
+		},
+	})
+	return nil
+}
@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// TestSyncRetention checks that a sync only serves the broadcasts still
+// within the history bounds.
+func TestSyncRetention(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     HistoryConfig
+		sizes      []int         // Ciphertext bytes of each message, sent in order
+		wait       time.Duration // Before the sync
+		wantSeqs   []uint64
+		wantOldest uint64
+	}{
+		{
+			name:       "within bounds",
+			config:     HistoryConfig{MaxMessages: 10, MaxBytes: 100, MaxAge: time.Hour},
+			sizes:      []int{40, 40},
+			wantSeqs:   []uint64{1, 2},
+			wantOldest: 1,
+		},
+		{
+			name:       "bytes",
+			config:     HistoryConfig{MaxMessages: 10, MaxBytes: 100},
+			sizes:      []int{40, 40, 40, 10},
+			wantSeqs:   []uint64{2, 3, 4},
+			wantOldest: 2,
+		},
+		{
+			name:       "age",
+			config:     HistoryConfig{MaxMessages: 10, MaxAge: time.Hour},
+			sizes:      []int{40, 40},
+			wait:       time.Hour + time.Millisecond,
+			wantOldest: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, WithHistory(tt.config))
+			alice := srv.connect("alice")
+			groupID := alice.createGroup("group")
+			for _, size := range tt.sizes {
+				alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: bytes.Repeat([]byte("x"), size)})
+			}
+			srv.clock.Advance(tt.wait)
+
+			alice.send(TypeSyncRequest, SyncRequestPayload{GroupID: groupID, FromSeq: 1})
+			var seqs []uint64
+			for {
+				frame := alice.nextEvent()
+				if frame.Type == TypeSyncResponse {
+					var resp SyncResponsePayload
+					frame.decode(alice, &resp)
+					if resp.OldestSeq != tt.wantOldest || resp.Count != len(tt.wantSeqs) {
+						t.Fatalf("sync served %d frames from seq %d, want %d from seq %d", resp.Count, resp.OldestSeq, len(tt.wantSeqs), tt.wantOldest)
+					}
+					break
+				}
+				seqs = append(seqs, frame.Seq)
+			}
+			if len(seqs) != len(tt.wantSeqs) {
+				t.Fatalf("got frames %v, want %v", seqs, tt.wantSeqs)
+			}
+			for i := range seqs {
+				if seqs[i] != tt.wantSeqs[i] {
+					t.Fatalf("got frames %v, want %v", seqs, tt.wantSeqs)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,613 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"chat-app/server/internal/domain"
+	"github.com/google/uuid"
+)
+
+// handleHello negotiates the protocol version. It must be the first frame on
+// every connection.
+func (h *Hub) handleHello(ctx context.Context, req *Request) error {
+	if req.Version != 0 {
+		return &Error{Code: CodeRequestFailed, Message: "protocol version already negotiated"}
+	}
+	body := req.Body.(*HelloPayload)
+
+	version := negotiateVersion(body.Versions)
+	if version == 0 {
+		return &Error{
+			Code:    CodeUnsupportedVersion,
+			Message: fmt.Sprintf("server supports protocol versions %d to %d", MinProtocolVersion, MaxProtocolVersion),
+		}
+	}
+	req.Client.setVersion(version)
+
+	req.Reply(OutgoingMessage{Type: TypeWelcome, Payload: WelcomePayload{Version: version, MaxFrameSize: h.frames.MaxFrameSize}})
+	return nil
+}
+
+// handleAuthenticate binds the connection to a user. A client presenting a
+// token resumes that identity; a client without one is issued a fresh
+// anonymous identity and a token to reconnect with.
+func (h *Hub) handleAuthenticate(ctx context.Context, req *Request) error {
+	if req.UserID != "" {
+		return &Error{Code: CodeRequestFailed, Message: "already authenticated"}
+	}
+	body := req.Body.(*AuthenticatePayload)
+
+	token := body.Token
+	var userID string
+	if token != "" {
+		id, err := h.jwtService.ValidateToken(token)
+		if err != nil {
+			return &Error{Code: CodeUnauthenticated, Message: "invalid token"}
+		}
+		userID = id
+	} else {
+		userID = uuid.New().String()
+		newToken, err := h.jwtService.GenerateToken(userID)
+		if err != nil {
+			return fmt.Errorf("could not issue token: %w", err)
+		}
+		token = newToken
+	}
+
+	user, err := h.chatService.RegisterUser(ctx, userID, body.DisplayName, body.PublicKey)
+	if err != nil {
+		return fmt.Errorf("could not register user: %w", err)
+	}
+	resumeToken, err := h.startSession(req.Client)
+	if err != nil {
+		return err
+	}
+
+	// A user may be connected from several devices. A new connection joins
+	// the group subscriptions the user already holds on the others, or the
+	// memberships they kept while offline.
+	h.mu.Lock()
+	req.Client.UserID = user.ID
+	groupIDs := h.comeOnlineLocked(user.ID)
+	for sibling := range h.clients[user.ID] {
+		groupIDs = h.subscriptionsLocked(sibling)
+		break
+	}
+	if h.clients[user.ID] == nil {
+		h.clients[user.ID] = make(map[*Client]bool)
+	}
+	h.clients[user.ID][req.Client] = true
+	for _, groupID := range groupIDs {
+		if h.groups[groupID] == nil {
+			h.groups[groupID] = make(map[*Client]bool)
+		}
+		h.groups[groupID][req.Client] = true
+	}
+	h.mu.Unlock()
+
+	groups := make([]GroupView, 0, len(groupIDs))
+	for _, groupID := range groupIDs {
+		if group, err := h.chatService.GetGroup(ctx, groupID); err == nil {
+			groups = append(groups, h.groupView(group))
+		}
+	}
+
+	req.Reply(OutgoingMessage{
+		Type: TypeAuthenticated,
+		Payload: AuthenticatedPayload{
+			Token:          token,
+			ResumeToken:    resumeToken,
+			User:           userView(user),
+			Groups:         groups,
+			UnreadMentions: user.GetUnreadMentions(),
+		},
+	})
+	h.presenceOnline(user)
+	h.deliverMailbox(ctx, req.Client, user.ID, groupIDs)
+	return nil
+}
+
+func (h *Hub) handleCreateGroup(ctx context.Context, req *Request) error {
+	body := req.Body.(*CreateGroupPayload)
+	if body.Name == "" || body.JoinTag == "" {
+		return invalidPayload("name and joinTag are required")
+	}
+
+	group, err := h.chatService.CreateGroup(ctx, body.Name, body.JoinTag, req.UserID)
+	if err != nil {
+		return err
+	}
+
+	h.subscribeUser(group.ID, req.UserID)
+	h.groupLog(group.ID).receipts.join(req.UserID, 0)
+
+	msg := OutgoingMessage{Type: TypeGroupCreated, Payload: h.groupView(group)}
+	req.Reply(msg)
+	h.sendToUser(req.UserID, msg, req.Client)
+	return nil
+}
+
+func (h *Hub) handleJoinGroup(ctx context.Context, req *Request) error {
+	body := req.Body.(*JoinGroupPayload)
+	if body.GroupID == "" {
+		return invalidPayload("groupId is required")
+	}
+
+	group, err := h.chatService.JoinGroup(ctx, body.GroupID, req.UserID)
+	if err != nil {
+		return err
+	}
+	h.cleanup.cancel(group.ID)
+
+	// The view is taken before subscribing, so anything broadcast in between
+	// shows up as a gap after LastSeq rather than going unnoticed.
+	view := h.groupView(group)
+	alreadyMember := h.subscribeUser(group.ID, req.UserID)
+
+	msg := OutgoingMessage{Type: TypeGroupJoined, Payload: view}
+	req.Reply(msg)
+	if alreadyMember {
+		return nil
+	}
+	h.groupLog(group.ID).receipts.join(req.UserID, view.LastSeq)
+	h.sendToUser(req.UserID, msg, req.Client)
+
+	user, err := h.chatService.GetUser(ctx, req.UserID)
+	if err != nil {
+		return nil
+	}
+	h.broadcastToGroupExceptUser(group.ID, OutgoingMessage{
+		Type:    TypeMemberJoined,
+		Payload: MemberJoinedPayload{GroupID: group.ID, User: userView(user)},
+	}, req.UserID)
+	return nil
+}
+
+func (h *Hub) handleLeaveGroup(ctx context.Context, req *Request) error {
+	body := req.Body.(*LeaveGroupPayload)
+	if body.GroupID == "" {
+		return invalidPayload("groupId is required")
+	}
+
+	h.stopTyping(body.GroupID, req.UserID)
+	h.unsubscribeUser(body.GroupID, req.UserID)
+	if err := h.leaveGroup(ctx, body.GroupID, req.UserID); err != nil {
+		return err
+	}
+
+	msg := OutgoingMessage{Type: TypeGroupLeft, Payload: GroupLeftPayload{GroupID: body.GroupID}}
+	req.Reply(msg)
+	h.sendToUser(req.UserID, msg, req.Client)
+	return nil
+}
+
+// handleSendMessage relays an end-to-end encrypted message to the other
+// members of a group, or to the other side of a direct conversation, which
+// is opened if needed. The server never inspects the ciphertext.
+func (h *Hub) handleSendMessage(ctx context.Context, req *Request) error {
+	body := req.Body.(*SendMessagePayload)
+	if (body.GroupID == "") == (body.RecipientID == "") || len(body.Ciphertext) == 0 {
+		return invalidPayload("ciphertext and one of groupId and recipientId are required")
+	}
+
+	groupID := body.GroupID
+	if body.RecipientID != "" {
+		var err error
+		if groupID, err = h.openDirect(ctx, req.UserID, body.RecipientID); err != nil {
+			return err
+		}
+	}
+	if !h.isSubscribed(groupID, req.Client) {
+		return errNotSubscribed
+	}
+	mentions, err := h.checkMentions(ctx, groupID, body.Mentions)
+	if err != nil {
+		return err
+	}
+
+	// A retry of a message that was already sent gets the original ack.
+	var sent *sentMessage
+	if body.ClientMessageID != "" {
+		var first bool
+		sent, first = h.dedupe.claim(req.UserID, body.ClientMessageID, groupID)
+		if !first {
+			if sent.err != nil {
+				return sent.err
+			}
+			if sent.groupID != groupID {
+				return invalidPayload("clientMessageId was already used in another group")
+			}
+			req.AckWith(AckPayload{Seq: sent.seq, MessageID: sent.messageID, Duplicate: true})
+			return nil
+		}
+	}
+
+	msg := NewMessagePayload{
+		GroupID:    groupID,
+		SenderID:   req.UserID,
+		MessageID:  uuid.New().String(),
+		ParentID:   body.ParentID,
+		Mentions:   mentions,
+		Ciphertext: body.Ciphertext,
+	}
+	seq, err := h.publishMessage(msg, req.Client, h.receiptTotal(ctx, groupID, req.UserID))
+	if err != nil {
+		if sent != nil {
+			h.dedupe.fail(sent, err)
+		}
+		return err
+	}
+	if sent != nil {
+		h.dedupe.finish(sent, seq, msg.MessageID)
+	}
+	h.notifyMentions(ctx, msg, seq)
+	req.AckWith(AckPayload{Seq: seq, MessageID: msg.MessageID})
+	return nil
+}
+
+// keyExchangeHandler returns a handler that forwards a key exchange step to a
+// single member of a group that the sender shares with them. Without a
+// group the step goes through the direct conversation with the target, which
+// is opened if needed. The frame keeps its type, and nextType tells the
+// recipient which frame to reply with.
+func (h *Hub) keyExchangeHandler(nextType string) HandlerFunc {
+	return func(ctx context.Context, req *Request) error {
+		body := req.Body.(*KeyExchangePayload)
+		if body.TargetUserID == "" || len(body.Data) == 0 {
+			return invalidPayload("targetUserId and data are required")
+		}
+
+		groupID := body.GroupID
+		if groupID == "" {
+			var err error
+			if groupID, err = h.openDirect(ctx, req.UserID, body.TargetUserID); err != nil {
+				return err
+			}
+		}
+		if !h.isSubscribed(groupID, req.Client) {
+			return errNotSubscribed
+		}
+
+		msg := OutgoingMessage{
+			Type: req.Type,
+			Payload: KeyExchangeForwardPayload{
+				GroupID:    groupID,
+				FromUserID: req.UserID,
+				Data:       body.Data,
+				NextType:   nextType,
+			},
+		}
+
+		// Every device of the target that is in the group gets the step.
+		delivered := false
+		h.mu.RLock()
+		for target := range h.clients[body.TargetUserID] {
+			if h.groups[groupID][target] {
+				target.enqueue(msg)
+				delivered = true
+			}
+		}
+		h.mu.RUnlock()
+		if !delivered {
+			return &Error{Code: CodeRequestFailed, Message: "target user is not connected to this group"}
+		}
+		req.Ack()
+		return nil
+	}
+}
+
+// handleUpdatePrivacy changes the user's privacy settings. Fields left out
+// of the payload keep their current value.
+func (h *Hub) handleUpdatePrivacy(ctx context.Context, req *Request) error {
+	body := req.Body.(*UpdatePrivacyPayload)
+
+	user, err := h.chatService.GetUser(ctx, req.UserID)
+	if err != nil {
+		return err
+	}
+	before := user.GetPrivacy()
+	settings := before
+	if body.HidePresence != nil {
+		settings.HidePresence = *body.HidePresence
+	}
+	if body.DisableReceipts != nil {
+		settings.DisableReceipts = *body.DisableReceipts
+	}
+	if user, err = h.chatService.UpdatePrivacy(ctx, req.UserID, settings); err != nil {
+		return err
+	}
+
+	msg := OutgoingMessage{Type: TypePrivacyUpdated, Payload: privacyView(settings)}
+	req.Reply(msg)
+	h.sendToUser(req.UserID, msg, req.Client)
+	if settings.HidePresence != before.HidePresence {
+		h.presenceVisibilityChanged(user, settings.HidePresence)
+	}
+	return nil
+}
+
+func (h *Hub) handleUpdateProfile(ctx context.Context, req *Request) error {
+	body := req.Body.(*UpdateProfilePayload)
+
+	user, err := h.chatService.UpdateUserProfile(ctx, req.UserID, body.DisplayName, body.ProfilePictureURL)
+	if err != nil {
+		return err
+	}
+
+	view := userView(user)
+	msg := OutgoingMessage{Type: TypeProfileUpdated, Payload: view}
+	req.Reply(msg)
+	h.sendToUser(req.UserID, msg, req.Client)
+
+	for _, groupID := range h.subscriptions(req.Client) {
+		h.broadcastToGroupExceptUser(groupID, OutgoingMessage{
+			Type:    TypeMemberUpdated,
+			Payload: MemberUpdatedPayload{GroupID: groupID, User: view},
+		}, req.UserID)
+	}
+	return nil
+}
+
+// handleUnregister handles a closed connection. A resumable session is kept
+// for the grace period; anything else is torn down right away.
+func (h *Hub) handleUnregister(client *Client) {
+	if h.detachSession(client) {
+		log.Printf("Client disconnected, session kept for %v", h.resume.Grace)
+		return
+	}
+	h.teardown(client)
+}
+
+// teardown removes a connection for good. When it was the user's last
+// connection, the user goes offline if there is a mailbox and is otherwise
+// removed from their groups and the system; if it was not, their
+// memberships stay with the remaining devices.
+func (h *Hub) teardown(client *Client) {
+	ctx := context.Background()
+
+	h.mu.Lock()
+	userID := client.UserID
+	if h.sessions[client.resumeToken] == client {
+		delete(h.sessions, client.resumeToken)
+	}
+	groupIDs := h.subscriptionsLocked(client)
+	for _, groupID := range groupIDs {
+		delete(h.groups[groupID], client)
+	}
+	last := false
+	if sessions, ok := h.clients[userID]; ok {
+		delete(sessions, client)
+		if len(sessions) == 0 {
+			delete(h.clients, userID)
+			last = true
+		}
+	}
+	h.mu.Unlock()
+	if last {
+		for _, groupID := range groupIDs {
+			h.stopTyping(groupID, userID)
+		}
+	}
+
+	client.close()
+	if !last {
+		log.Println("Client disconnected")
+		return
+	}
+	h.presenceOffline(userID, groupIDs)
+	if h.mailbox != nil {
+		h.goOffline(userID, groupIDs)
+		log.Printf("User %s went offline", userID)
+		return
+	}
+	h.removeUser(ctx, userID, groupIDs)
+}
+
+// removeUser makes the user leave their groups and removes them from the system.
+func (h *Hub) removeUser(ctx context.Context, userID string, groupIDs []string) {
+	for _, groupID := range groupIDs {
+		if err := h.leaveGroup(ctx, groupID, userID); err != nil {
+			log.Printf("could not remove user %s from group %s: %v", userID, groupID, err)
+		}
+	}
+	if err := h.chatService.UnregisterUser(ctx, userID); err != nil {
+		log.Printf("could not unregister user %s: %v", userID, err)
+	}
+	h.limiter.forget(userID)
+	log.Printf("User %s disconnected", userID)
+}
+
+// leaveGroup removes the user from the group, drops their receipt
+// watermarks and notifies the remaining members. If nobody is left, the group
+// is scheduled for deletion.
+func (h *Hub) leaveGroup(ctx context.Context, groupID, userID string) error {
+	group, newOwnerID, err := h.chatService.LeaveGroup(ctx, groupID, userID)
+	if err != nil {
+		return err
+	}
+	h.mu.RLock()
+	gl := h.logs[groupID]
+	h.mu.RUnlock()
+	if gl != nil {
+		gl.receipts.forget(userID)
+	}
+	if group.IsEmpty() {
+		h.cleanup.schedule(groupID)
+		return nil
+	}
+	h.BroadcastToGroup(groupID, OutgoingMessage{
+		Type:    TypeMemberLeft,
+		Payload: MemberLeftPayload{GroupID: groupID, UserID: userID, NewOwnerID: newOwnerID},
+	}, nil)
+	return nil
+}
+
+// expireGroup deletes a group whose cleanup timeout has elapsed, unless
+// someone has joined it in the meantime.
+func (h *Hub) expireGroup(groupID string) {
+	deleted, err := h.chatService.DeleteGroupIfEmpty(context.Background(), groupID)
+	if err != nil {
+		log.Printf("could not delete group %s: %v", groupID, err)
+		return
+	}
+	if !deleted {
+		return
+	}
+
+	h.mu.Lock()
+	if len(h.groups[groupID]) == 0 {
+		delete(h.groups, groupID)
+	}
+	gl := h.logs[groupID]
+	delete(h.logs, groupID)
+	h.mu.Unlock()
+	h.forgetTyping(groupID)
+
+	if gl != nil {
+		gl.mu.Lock()
+		gl.history.wipe()
+		gl.retained = nil
+		gl.mu.Unlock()
+	}
+	log.Printf("Deleted empty group %s", groupID)
+}
+
+// BroadcastToGroup queues a message for every client subscribed to the group,
+// except the optional excluded client. The message is stamped with the
+// group's next sequence number and encoded only once, and the encoded frame
+// is shared by all recipients.
+// It returns the sequence number the message was stamped with.
+func (h *Hub) BroadcastToGroup(groupID string, msg OutgoingMessage, exclude *Client) uint64 {
+	return h.publish(groupID, msg, func(member *Client) bool { return member != exclude })
+}
+
+// broadcastToGroupExceptUser is like BroadcastToGroup but skips every
+// connection of the given user.
+func (h *Hub) broadcastToGroupExceptUser(groupID string, msg OutgoingMessage, userID string) uint64 {
+	return h.publish(groupID, msg, func(member *Client) bool { return member.UserID != userID })
+}
+
+// sendToUser queues a message for every connection of the user, except the
+// optional excluded client.
+func (h *Hub) sendToUser(userID string, msg OutgoingMessage, exclude *Client) {
+	frame := newSharedFrame(msg)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients[userID] {
+		if client != exclude {
+			client.enqueueShared(frame)
+		}
+	}
+}
+
+// subscribeUser subscribes every connection of the user to the group.
+// It reports whether the user was already subscribed.
+func (h *Hub) subscribeUser(groupID, userID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	members := h.groups[groupID]
+	if members == nil {
+		members = make(map[*Client]bool)
+		h.groups[groupID] = members
+	}
+	already := false
+	for client := range h.clients[userID] {
+		already = already || members[client]
+		members[client] = true
+	}
+	return already
+}
+
+// unsubscribeUser removes every connection of the user from the group.
+func (h *Hub) unsubscribeUser(groupID, userID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for client := range h.clients[userID] {
+		delete(h.groups[groupID], client)
+	}
+}
+
+func (h *Hub) isSubscribed(groupID string, client *Client) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.groups[groupID][client]
+}
+
+// subscriptions returns the IDs of all groups the client is subscribed to.
+func (h *Hub) subscriptions(client *Client) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.subscriptionsLocked(client)
+}
+
+func (h *Hub) subscriptionsLocked(client *Client) []string {
+	var groupIDs []string
+	for groupID, members := range h.groups {
+		if members[client] {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	return groupIDs
+}
+
+func (h *Hub) userID(client *Client) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return client.UserID
+}
+
+var errNotSubscribed = &Error{Code: CodeNotMember, Message: "not a member of this group"}
+
+func invalidPayload(message string) error {
+	return &Error{Code: CodeInvalidPayload, Message: message}
+}
+
+func userView(user *domain.User) UserView {
+	return UserView{
+		ID:                user.ID,
+		DisplayName:       user.DisplayName,
+		ProfilePictureURL: user.ProfilePictureURL,
+		PublicKey:         user.PublicKey,
+	}
+}
+
+// groupView describes a group as sent to its members: its details, the
+// sequence number of its latest broadcast, from which a member can detect
+// gaps, and each member's presence.
+func (h *Hub) groupView(group *domain.Group) GroupView {
+	members := group.GetMembers()
+	view := buildGroupView(group, members)
+	view.LastSeq = h.lastSeq(group.ID)
+	for i, member := range members {
+		state, lastSeen := h.presenceOf(member)
+		view.Members[i].Presence = state
+		if !lastSeen.IsZero() {
+			view.Members[i].LastSeen = lastSeen.UnixMilli()
+		}
+	}
+	return view
+}
+
+func privacyView(settings domain.PrivacySettings) PrivacyView {
+	return PrivacyView{HidePresence: settings.HidePresence, DisableReceipts: settings.DisableReceipts}
+}
+
+// buildGroupView lists members in the order given, so that callers can fill
+// in per-member details by index.
+func buildGroupView(group *domain.Group, members []*domain.User) GroupView {
+	views := make([]UserView, 0, len(members))
+	for _, member := range members {
+		views = append(views, userView(member))
+	}
+	return GroupView{
+		ID:                group.ID,
+		Name:              group.Name,
+		Direct:            group.Direct,
+		JoinTag:           group.JoinTag,
+		ProfilePictureURL: group.ProfilePictureURL,
+		OwnerID:           group.GetOwnerID(),
+		Members:           views,
+	}
+}
@@ -0,0 +1,265 @@
+package websocket
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// HistoryConfig bounds the recent messages each group keeps in memory for
+// members who join or come back later. The oldest messages are dropped first
+// once any bound is exceeded. MaxMessages of zero disables history; a zero
+// MaxBytes or MaxAge leaves that bound off. The broadcasts kept for
+// sync_request are held to MaxBytes and MaxAge as well.
+type HistoryConfig struct {
+	MaxMessages int
+	MaxBytes    int // Total ciphertext bytes per group
+	MaxAge      time.Duration
+}
+
+// DefaultHistoryConfig keeps up to a day of messages, capped at 500 messages
+// or 1 MiB of ciphertext per group.
+var DefaultHistoryConfig = HistoryConfig{MaxMessages: 500, MaxBytes: 1 << 20, MaxAge: 24 * time.Hour}
+
+// WithHistory configures per-group message history.
+func WithHistory(config HistoryConfig) Option {
+	return func(h *Hub) { h.history = config }
+}
+
+const (
+	defaultHistoryPage = 50
+	maxHistoryPage     = 100
+)
+
+// envelope is a message as kept in a group's history. The server only ever
+// holds the ciphertext.
+type envelope struct {
+	seq        uint64
+	timestamp  int64 // Unix milliseconds
+	senderID   string
+	messageID  string
+	parentID   string // Thread root, for replies
+	mentions   []string
+	thread     *thread // Replies so far, for thread roots
+	ciphertext []byte  // Nil once deleted
+	edited     bool
+	deleted    bool
+	reactions  []*reaction // In the order they were first used
+	mailboxed  []string    // Users who were sent a mailbox copy
+	edits      []uint64    // Sequence numbers of the message_edited frames
+}
+
+// payload returns the message as it currently stands, for replay.
+func (e *envelope) payload(groupID string) NewMessagePayload {
+	return NewMessagePayload{
+		GroupID:    groupID,
+		SenderID:   e.senderID,
+		MessageID:  e.messageID,
+		ParentID:   e.parentID,
+		Mentions:   e.mentions,
+		Ciphertext: e.ciphertext,
+		Edited:     e.edited,
+		Deleted:    e.deleted,
+	}
+}
+
+// view returns the message as served from history.
+func (e *envelope) view() HistoryMessage {
+	msg := HistoryMessage{
+		Seq:        e.seq,
+		Timestamp:  e.timestamp,
+		SenderID:   e.senderID,
+		MessageID:  e.messageID,
+		ParentID:   e.parentID,
+		Mentions:   e.mentions,
+		Ciphertext: e.ciphertext,
+		Edited:     e.edited,
+		Deleted:    e.deleted,
+		Reactions:  e.tallies(),
+	}
+	if e.thread != nil {
+		msg.ReplyCount = e.thread.replies
+		msg.LastReplyAt = e.thread.lastReplyAt
+	}
+	return msg
+}
+
+// messageHistory holds a group's recent messages, oldest first.
+type messageHistory struct {
+	messages []*envelope
+	byID     map[string]*envelope
+	bytes    int
+}
+
+func (m *messageHistory) add(e *envelope, config HistoryConfig, now time.Time) {
+	if config.MaxMessages <= 0 {
+		return
+	}
+	if m.byID == nil {
+		m.byID = make(map[string]*envelope)
+	}
+	m.messages = append(m.messages, e)
+	m.byID[e.messageID] = e
+	m.bytes += len(e.ciphertext)
+	m.trim(config, now)
+}
+
+// find returns a retained message by its ID, or nil.
+func (m *messageHistory) find(messageID string) *envelope {
+	return m.byID[messageID]
+}
+
+// setCiphertext changes a retained message's ciphertext, keeping the byte
+// count in step.
+func (m *messageHistory) setCiphertext(e *envelope, ciphertext []byte) {
+	m.bytes += len(ciphertext) - len(e.ciphertext)
+	e.ciphertext = ciphertext
+}
+
+// trim drops messages from the front until every bound holds.
+func (m *messageHistory) trim(config HistoryConfig, now time.Time) {
+	cutoff := now.Add(-config.MaxAge).UnixMilli()
+	n := 0
+	for ; n < len(m.messages); n++ {
+		e := m.messages[n]
+		over := len(m.messages)-n > config.MaxMessages ||
+			(config.MaxBytes > 0 && m.bytes > config.MaxBytes) ||
+			(config.MaxAge > 0 && e.timestamp < cutoff)
+		if !over {
+			break
+		}
+		m.bytes -= len(e.ciphertext)
+		delete(m.byID, e.messageID)
+		m.messages[n] = nil
+	}
+	m.messages = m.messages[n:]
+}
+
+// page returns up to limit messages accepted by include that are older than
+// beforeSeq, oldest first, and whether even older ones remain. A zero
+// beforeSeq starts at the newest.
+func (m *messageHistory) page(beforeSeq uint64, limit int, include func(*envelope) bool) ([]*envelope, bool) {
+	i := len(m.messages)
+	if beforeSeq != 0 {
+		i = sort.Search(len(m.messages), func(i int) bool { return m.messages[i].seq >= beforeSeq })
+	}
+	var page []*envelope
+	for i--; i >= 0 && len(page) < limit; i-- {
+		if include(m.messages[i]) {
+			page = append(page, m.messages[i])
+		}
+	}
+	more := false
+	for ; i >= 0 && !more; i-- {
+		more = include(m.messages[i])
+	}
+	for l, r := 0, len(page)-1; l < r; l, r = l+1, r-1 {
+		page[l], page[r] = page[r], page[l]
+	}
+	return page, more
+}
+
+// wipe forgets every message, for example because the group was deleted.
+func (m *messageHistory) wipe() {
+	m.messages = nil
+	m.byID = nil
+	m.bytes = 0
+}
+
+// publishMessage broadcasts a chat message like BroadcastToGroup, records it
+// in the group's history under the same sequence number, and leaves a copy in
+// the mailbox of every member who is offline. Receipts for it are counted
+// out of receiptTotal recipients. A reply is added to its thread, whose
+// participants are notified once the locks are released; it fails if the
+// thread's first message is no longer in history.
+func (h *Hub) publishMessage(payload NewMessagePayload, exclude *Client, receiptTotal int) (uint64, error) {
+	gl := h.groupLog(payload.GroupID)
+	defer gl.flushMailbox()
+	seq, notice, err := h.appendMessage(gl, payload, exclude, receiptTotal)
+	if err != nil {
+		return 0, err
+	}
+	if notice != nil {
+		h.notifyThread(notice)
+	}
+	return seq, nil
+}
+
+// appendMessage does the part of publishMessage that happens under the
+// group log's lock and the hub's, and returns the thread_reply to send
+// afterwards, if any.
+func (h *Hub) appendMessage(gl *groupLog, payload NewMessagePayload, exclude *Client, receiptTotal int) (uint64, *threadNotice, error) {
+	gl.mu.Lock()
+	defer gl.mu.Unlock()
+	now := h.clock.Now()
+
+	var root *envelope
+	if payload.ParentID != "" {
+		gl.history.trim(h.history, now)
+		if root = gl.history.threadRoot(payload.ParentID); root == nil || root.deleted {
+			return 0, nil, errMessageNotFound
+		}
+		payload.ParentID = root.messageID
+	}
+
+	frame := gl.stamp(OutgoingMessage{Type: TypeNewMessage, Payload: payload}, h.history, now)
+	gl.receipts.track(frame.msg.Seq, payload.SenderID, receiptTotal)
+
+	e := &envelope{
+		seq:        frame.msg.Seq,
+		timestamp:  frame.msg.Timestamp,
+		senderID:   payload.SenderID,
+		messageID:  payload.MessageID,
+		parentID:   payload.ParentID,
+		mentions:   payload.Mentions,
+		ciphertext: payload.Ciphertext,
+	}
+	gl.history.add(e, h.history, now)
+	var notice *threadNotice
+	if root != nil {
+		notice = newThreadNotice(root, payload, root.reply(payload.SenderID, frame.msg.Timestamp))
+	}
+
+	// The offline members are picked under the same lock as the fan-out, so
+	// a member who comes back online either gets the message live or finds
+	// it in their mailbox, which is flushed before it is drained.
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.fanOutLocked(payload.GroupID, frame, func(member *Client) bool { return member != exclude })
+	e.mailboxed = h.depositLocked(gl, payload, frame.msg.Seq, now)
+	return frame.msg.Seq, notice, nil
+}
+
+// handleFetchHistory pages back through a group's recent messages.
+func (h *Hub) handleFetchHistory(ctx context.Context, req *Request) error {
+	body := req.Body.(*FetchHistoryPayload)
+	if body.GroupID == "" {
+		return invalidPayload("groupId is required")
+	}
+	limit := body.Limit
+	if limit <= 0 {
+		limit = defaultHistoryPage
+	}
+	if limit > maxHistoryPage {
+		limit = maxHistoryPage
+	}
+	if !h.isSubscribed(body.GroupID, req.Client) {
+		return errNotSubscribed
+	}
+
+	gl := h.groupLog(body.GroupID)
+	gl.mu.Lock()
+	gl.history.trim(h.history, h.clock.Now())
+	page, more := gl.history.page(body.BeforeSeq, limit, func(e *envelope) bool { return e.parentID == "" })
+	messages := make([]HistoryMessage, len(page))
+	for i, e := range page {
+		messages[i] = e.view()
+	}
+	gl.mu.Unlock()
+
+	req.Reply(OutgoingMessage{
+		Type:    TypeHistory,
+		Payload: HistoryPayload{GroupID: body.GroupID, Messages: messages, HasMore: more},
+	})
+	return nil
+}
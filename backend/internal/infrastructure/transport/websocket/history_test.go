@@ -0,0 +1,101 @@
+package websocket
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+var historyEpoch = time.Unix(1700000000, 0)
+
+// historyOf returns a history holding messages with seqs 1..n, sent a minute
+// apart and ending at historyEpoch, each with size bytes of ciphertext.
+func historyOf(n, size int) *messageHistory {
+	m := &messageHistory{byID: make(map[string]*envelope)}
+	for seq := 1; seq <= n; seq++ {
+		e := &envelope{
+			seq:        uint64(seq),
+			timestamp:  historyEpoch.Add(time.Duration(seq-n) * time.Minute).UnixMilli(),
+			messageID:  fmt.Sprintf("m%d", seq),
+			ciphertext: make([]byte, size),
+		}
+		m.messages = append(m.messages, e)
+		m.byID[e.messageID] = e
+		m.bytes += size
+	}
+	return m
+}
+
+func seqsOf(envelopes []*envelope) []uint64 {
+	seqs := make([]uint64, len(envelopes))
+	for i, e := range envelopes {
+		seqs[i] = e.seq
+	}
+	return seqs
+}
+
+func TestMessageHistoryTrim(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HistoryConfig
+		want   []uint64
+	}{
+		{name: "within bounds", config: HistoryConfig{MaxMessages: 10}, want: []uint64{1, 2, 3, 4, 5}},
+		{name: "message count", config: HistoryConfig{MaxMessages: 3}, want: []uint64{3, 4, 5}},
+		{name: "bytes", config: HistoryConfig{MaxMessages: 10, MaxBytes: 25}, want: []uint64{4, 5}},
+		{name: "bytes exactly at the bound", config: HistoryConfig{MaxMessages: 10, MaxBytes: 30}, want: []uint64{3, 4, 5}},
+		{name: "age", config: HistoryConfig{MaxMessages: 10, MaxAge: 2 * time.Minute}, want: []uint64{3, 4, 5}},
+		{name: "tightest bound wins", config: HistoryConfig{MaxMessages: 4, MaxBytes: 100, MaxAge: time.Minute}, want: []uint64{4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := historyOf(5, 10)
+			m.trim(tt.config, historyEpoch)
+			if got := seqsOf(m.messages); !slices.Equal(got, tt.want) {
+				t.Fatalf("kept seqs %v, want %v", got, tt.want)
+			}
+			if want := 10 * len(tt.want); m.bytes != want {
+				t.Fatalf("bytes = %d, want %d", m.bytes, want)
+			}
+			if len(m.byID) != len(tt.want) {
+				t.Fatalf("%d messages indexed by ID, want %d", len(m.byID), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestMessageHistoryPage(t *testing.T) {
+	all := func(*envelope) bool { return true }
+	odd := func(e *envelope) bool { return e.seq%2 == 1 }
+	tests := []struct {
+		name      string
+		beforeSeq uint64
+		limit     int
+		include   func(*envelope) bool
+		want      []uint64
+		wantMore  bool
+	}{
+		{name: "newest", limit: 3, include: all, want: []uint64{8, 9, 10}, wantMore: true},
+		{name: "everything", limit: 20, include: all, want: []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{name: "before a seq", beforeSeq: 5, limit: 3, include: all, want: []uint64{2, 3, 4}, wantMore: true},
+		{name: "reaches the oldest", beforeSeq: 4, limit: 3, include: all, want: []uint64{1, 2, 3}},
+		{name: "before the oldest", beforeSeq: 1, limit: 3, include: all, want: nil},
+		{name: "before a seq past the newest", beforeSeq: 50, limit: 2, include: all, want: []uint64{9, 10}, wantMore: true},
+		{name: "filtered", limit: 3, include: odd, want: []uint64{5, 7, 9}, wantMore: true},
+		{name: "filtered to the oldest", beforeSeq: 5, limit: 3, include: odd, want: []uint64{1, 3}},
+		{name: "filtered with nothing older", beforeSeq: 3, limit: 1, include: odd, want: []uint64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := historyOf(10, 1)
+			page, more := m.page(tt.beforeSeq, tt.limit, tt.include)
+			if got := seqsOf(page); !slices.Equal(got, tt.want) {
+				t.Fatalf("page seqs %v, want %v", got, tt.want)
+			}
+			if more != tt.wantMore {
+				t.Fatalf("more = %v, want %v", more, tt.wantMore)
+			}
+		})
+	}
+}
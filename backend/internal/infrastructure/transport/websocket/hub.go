@@ -7,34 +7,110 @@ import (
 	"time"
 
 	"chat-app/server/internal/application"
+	"chat-app/server/internal/infrastructure/auth"
+	"chat-app/server/internal/infrastructure/clock"
 )
 
 const groupCleanupTimeout = 5 * time.Minute
 
 // Hub maintains the set of active clients and broadcasts messages to the clients.
 type Hub struct {
-	clients     map[string]*Client          // Map userID to client
-	groups      map[string]map[*Client]bool // Map groupID to set of clients
+	clients     map[string]map[*Client]bool        // Map userID to the user's connections
+	groups      map[string]map[*Client]bool        // Map groupID to set of clients
+	logs        map[string]*groupLog               // Map groupID to its ordered broadcasts
+	typing      map[string]map[string]*typingState // Map groupID to the users typing in it, guarded by typingMu
 	register    chan *Client
 	unregister  chan *Client
 	chatService *application.ChatService
+	jwtService  *auth.JWTService
+	handlers    *Registry
+	clock       clock.Clock
+	cleanup     *groupCleaner
+	dedupe      *sendDeduper
 	mu          sync.RWMutex
+
+	sendPolicies map[string]SendPolicy // Connection class to send policy
+	sendCounters sendCounters
+
+	compression         CompressionConfig
+	buffers             BufferConfig
+	compressionCounters compressionCounters
+
+	limiter *rateLimiter
+	frames  FrameConfig
+
+	history  HistoryConfig
+	resume   ResumeConfig
+	sessions map[string]*Client // Map resume token to session
+
+	presence   map[string]*presence // Map userID to the presence of connected users
+	presenceMu sync.Mutex           // Guards presence; taken before mu, never after
+
+	typingMu sync.Mutex // Guards typing; taken before mu, never after
+
+	mailbox        *application.MailboxService // Nil when store-and-forward is off
+	offline        map[string]*offlineUser     // Map userID to users kept while offline
+	offlineMembers map[string]map[string]bool  // Map groupID to its offline members
+
+	groupCleanupTimeout time.Duration
+	sendDedupeWindow    time.Duration
+}
+
+// Option configures optional Hub behaviour.
+type Option func(*Hub)
+
+// WithClock replaces the wall clock used for timers, mainly for tests.
+func WithClock(c clock.Clock) Option {
+	return func(h *Hub) { h.clock = c }
 }
 
-func NewHub(chatService *application.ChatService) *Hub {
-	return &Hub{
-		clients:     make(map[string]*Client),
+// WithGroupCleanupTimeout sets how long a group may stay empty before it is deleted.
+func WithGroupCleanupTimeout(d time.Duration) Option {
+	return func(h *Hub) { h.groupCleanupTimeout = d }
+}
+
+func NewHub(chatService *application.ChatService, jwtService *auth.JWTService, opts ...Option) *Hub {
+	h := &Hub{
+		clients:     make(map[string]map[*Client]bool),
 		groups:      make(map[string]map[*Client]bool),
+		logs:        make(map[string]*groupLog),
+		typing:      make(map[string]map[string]*typingState),
 		register:    make(chan *Client),
 		unregister:  make(chan *Client),
 		chatService: chatService,
+		jwtService:  jwtService,
+		handlers:    NewRegistry(),
+		clock:       clock.New(),
+
+		sendPolicies: map[string]SendPolicy{"": DefaultSendPolicy},
+		compression:  DefaultCompressionConfig,
+		buffers:      DefaultBufferConfig,
+		history:      DefaultHistoryConfig,
+		resume:       DefaultResumeConfig,
+		limiter:      newRateLimiter(DefaultRateLimitConfig),
+		frames:       DefaultFrameConfig,
+		sessions:     make(map[string]*Client),
+
+		presence:       make(map[string]*presence),
+		offline:        make(map[string]*offlineUser),
+		offlineMembers: make(map[string]map[string]bool),
+
+		groupCleanupTimeout: groupCleanupTimeout,
+		sendDedupeWindow:    sendDedupeWindow,
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.cleanup = newGroupCleaner(h.clock, h.groupCleanupTimeout, h.expireGroup)
+	h.dedupe = newSendDeduper(h.clock, h.sendDedupeWindow)
+	h.registerHandlers()
+	return h
 }
 
 func (h *Hub) Run() {
 	for {
 		select {
-		case client := <-h.register:
+		case <-h.register:
 			// Just register the connection for now. Authentication will assign the UserID.
 			log.Println("Client connected")
 		case client := <-h.unregister:
@@ -43,29 +119,75 @@ func (h *Hub) Run() {
 	}
 }
 
+// Stats groups the hub's transport counters.
+type Stats struct {
+	Send        SendStats        `json:"send"`
+	Compression CompressionStats `json:"compression"`
+}
+
+// Stats returns a snapshot of the hub's transport counters.
+func (h *Hub) Stats() Stats {
+	return Stats{Send: h.SendStats(), Compression: h.CompressionStats()}
+}
+
+// Handle registers a handler for a message type. It lets other packages add
+// frame types without editing the hub.
+func (h *Hub) Handle(msgType string, handler HandlerFunc, middleware ...Middleware) {
+	h.handlers.Handle(msgType, handler, middleware...)
+}
+
+// registerHandlers installs the built-in frame handlers.
+func (h *Hub) registerHandlers() {
+	h.handlers.Use(Logging())
+	h.handlers.Handle(TypeHello, h.handleHello, Decode[HelloPayload]())
+	h.handlers.Handle(TypeAuthenticate, h.handleAuthenticate, Decode[AuthenticatePayload]())
+	h.handlers.Handle(TypeResume, h.handleResume, Decode[ResumePayload]())
+	h.handlers.Handle(TypeChunkStart, h.handleChunkStart, Decode[ChunkStartPayload]())
+	h.handlers.Handle(TypeChunk, h.handleChunk, Decode[ChunkPayload]())
+	h.handlers.Handle(TypeChunkEnd, h.handleChunkEnd, Decode[ChunkEndPayload]())
+	h.handlers.Handle(TypeMailboxAck, h.handleMailboxAck, RequireAuth(), Decode[MailboxAckPayload]())
+	h.handlers.Handle(TypeCreateGroup, h.handleCreateGroup, RequireAuth(), Decode[CreateGroupPayload]())
+	h.handlers.Handle(TypeJoinGroup, h.handleJoinGroup, RequireAuth(), Decode[JoinGroupPayload]())
+	h.handlers.Handle(TypeLeaveGroup, h.handleLeaveGroup, RequireAuth(), Decode[LeaveGroupPayload]())
+	h.handlers.Handle(TypeSendMessage, h.handleSendMessage, RequireAuth(), Decode[SendMessagePayload]())
+	h.handlers.Handle(TypeEditMessage, h.handleEditMessage, RequireAuth(), Decode[EditMessagePayload]())
+	h.handlers.Handle(TypeDeleteMessage, h.handleDeleteMessage, RequireAuth(), Decode[DeleteMessagePayload]())
+	h.handlers.Handle(TypeAddReaction, h.reactionHandler(true), RequireAuth(), Decode[ReactionPayload]())
+	h.handlers.Handle(TypeRemoveReaction, h.reactionHandler(false), RequireAuth(), Decode[ReactionPayload]())
+	h.handlers.Handle(TypeFetchHistory, h.handleFetchHistory, RequireAuth(), Decode[FetchHistoryPayload]())
+	h.handlers.Handle(TypeFetchThread, h.handleFetchThread, RequireAuth(), Decode[FetchThreadPayload]())
+	h.handlers.Handle(TypeSyncRequest, h.handleSyncRequest, RequireAuth(), Decode[SyncRequestPayload]())
+	h.handlers.Handle(TypeDelivered, h.receiptHandler(receiptDelivered), RequireAuth(), Decode[ReceiptPayload]())
+	h.handlers.Handle(TypeRead, h.receiptHandler(receiptRead), RequireAuth(), Decode[ReceiptPayload]())
+	h.handlers.Handle(TypeTypingStart, h.handleTypingStart, RequireAuth(), Decode[TypingPayload]())
+	h.handlers.Handle(TypeTypingStop, h.handleTypingStop, RequireAuth(), Decode[TypingPayload]())
+	h.handlers.Handle(TypeKeyExchangeOffer, h.keyExchangeHandler(TypeKeyExchangeAnswer), RequireAuth(), Decode[KeyExchangePayload]())
+	h.handlers.Handle(TypeKeyExchangeAnswer, h.keyExchangeHandler(TypeKeyExchangeComplete), RequireAuth(), Decode[KeyExchangePayload]())
+	h.handlers.Handle(TypeUpdateProfile, h.handleUpdateProfile, RequireAuth(), Decode[UpdateProfilePayload]())
+	h.handlers.Handle(TypeMuteGroup, h.handleMuteGroup, RequireAuth(), Decode[MuteGroupPayload]())
+	h.handlers.Handle(TypeAckMentions, h.handleAckMentions, RequireAuth(), Decode[AckMentionsPayload]())
+	h.handlers.Handle(TypeUpdatePrivacy, h.handleUpdatePrivacy, RequireAuth(), Decode[UpdatePrivacyPayload]())
+}
+
 func (h *Hub) handleMessage(client *Client, msg IncomingMessage) {
-	// A giant switch statement is not ideal, but it's simple for this example.
-	// A better approach would be a map of message types to handler functions.
-	ctx := context.Background()
-
-	switch msg.Type {
-	case "authenticate":
-		h.handleAuthenticate(client, msg.Payload)
-	case "create_group":
-		h.handleCreateGroup(client, msg.Payload)
-	case "join_group":
-		h.handleJoinGroup(client, msg.Payload)
-	case "leave_group":
-		h.handleLeaveGroup(client, msg.Payload)
-	case "send_message":
-		h.handleSendMessage(client, msg.Payload)
-	case "key_exchange_offer":
-		h.handleKeyExchange(client, msg.Payload, "key_exchange_answer")
-	case "key_exchange_answer":
-		h.handleKeyExchange(client, msg.Payload, "key_exchange_complete")
-	case "update_profile":
-		h.handleUpdateProfile(client, msg.Payload)
-	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+	req := &Request{
+		Hub:     h,
+		Client:  client,
+		ID:      msg.ID,
+		UserID:  h.userID(client),
+		Version: client.protocolVersion(),
+		Type:    msg.Type,
+		Payload: msg.Payload,
+	}
+	if !h.admit(req) {
+		return
+	}
+	if req.Version == 0 && req.Type != TypeHello {
+		req.Reply(errorMessage(req.Type, &Error{Code: CodeHelloRequired, Message: "the first frame must be hello"}))
+		return
+	}
+	if req.UserID != "" {
+		h.touch(req.UserID)
 	}
+	h.handlers.Dispatch(context.Background(), req)
 }
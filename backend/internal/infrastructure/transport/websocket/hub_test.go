@@ -0,0 +1,232 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"chat-app/server/internal/application"
+	"chat-app/server/internal/infrastructure/clock"
+	"chat-app/server/internal/infrastructure/persistence/inmemory"
+	"github.com/gorilla/websocket"
+)
+
+// testServer runs a hub behind a real HTTP server, on a fake clock, so tests
+// talk to it the way clients do.
+type testServer struct {
+	t     *testing.T
+	hub   *Hub
+	clock *clock.Fake
+	url   string
+}
+
+func newTestServer(t *testing.T, opts ...Option) *testServer {
+	t.Helper()
+	hub, fake := newTestHub(t, opts...)
+	go hub.Run()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ServeWs(hub, w, r) }))
+	t.Cleanup(srv.Close)
+	return &testServer{t: t, hub: hub, clock: fake, url: "ws" + strings.TrimPrefix(srv.URL, "http")}
+}
+
+// testMailbox returns a mailbox option for a hub, with the default limits.
+func testMailbox() Option {
+	return WithMailbox(application.NewMailboxService(inmemory.NewInMemoryMailboxRepository(), application.DefaultMailboxConfig))
+}
+
+// goOffline closes the user's only connection and waits until the hub keeps
+// them as an offline member. The hub needs a mailbox, and resumption has to
+// be off or the session is kept instead.
+func (s *testServer) goOffline(c *testConn) {
+	s.t.Helper()
+	c.conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		s.hub.mu.RLock()
+		offline := s.hub.offline[c.userID] != nil
+		s.hub.mu.RUnlock()
+		if offline {
+			return
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("user %s did not go offline", c.userID)
+		}
+	}
+}
+
+// testConn is a client connection. Frames are written and read in the
+// negotiated wire format.
+type testConn struct {
+	t     *testing.T
+	conn  *websocket.Conn
+	codec Codec
+
+	userID      string
+	token       string
+	resumeToken string
+
+	requests int // Frames sent, for correlation IDs
+}
+
+// testRequest is a frame as a client sends it.
+type testRequest struct {
+	ID      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload,omitempty"`
+}
+
+// testFrame is a frame as a client receives it, with the payload still
+// encoded.
+type testFrame struct {
+	ID        string     `json:"id,omitempty"`
+	Type      string     `json:"type"`
+	Seq       uint64     `json:"seq,omitempty"`
+	Timestamp int64      `json:"timestamp,omitempty"`
+	Payload   RawPayload `json:"payload"`
+}
+
+// open connects without saying hello, offering the given subprotocols.
+func (s *testServer) open(subprotocols ...string) *testConn {
+	s.t.Helper()
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	conn, _, err := dialer.Dial(s.url, nil)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.t.Cleanup(func() { conn.Close() })
+	return &testConn{t: s.t, conn: conn, codec: codecFor(conn.Subprotocol())}
+}
+
+// dial connects and negotiates the protocol version.
+func (s *testServer) dial(subprotocols ...string) *testConn {
+	s.t.Helper()
+	c := s.open(subprotocols...)
+	c.send(TypeHello, HelloPayload{Versions: []int{MaxProtocolVersion}})
+	c.expect(TypeWelcome)
+	return c
+}
+
+// connect dials and authenticates as a new anonymous user.
+func (s *testServer) connect(name string) *testConn {
+	s.t.Helper()
+	return s.login(s.dial(), AuthenticatePayload{DisplayName: name, PublicKey: "key-" + name})
+}
+
+// reconnect dials and authenticates as the user the token belongs to.
+func (s *testServer) reconnect(token string) *testConn {
+	s.t.Helper()
+	return s.login(s.dial(), AuthenticatePayload{Token: token})
+}
+
+func (s *testServer) login(c *testConn, body AuthenticatePayload) *testConn {
+	s.t.Helper()
+	c.send(TypeAuthenticate, body)
+	var auth AuthenticatedPayload
+	c.expect(TypeAuthenticated).decode(c, &auth)
+	c.userID, c.token, c.resumeToken = auth.User.ID, auth.Token, auth.ResumeToken
+	return c
+}
+
+// send sends a frame with the next correlation ID, so that it is acked.
+func (c *testConn) send(msgType string, payload interface{}) {
+	c.t.Helper()
+	c.requests++
+	c.request(strconv.Itoa(c.requests), msgType, payload)
+}
+
+// request sends a frame with a correlation ID.
+func (c *testConn) request(id, msgType string, payload interface{}) {
+	c.t.Helper()
+	data, err := c.codec.Marshal(testRequest{ID: id, Type: msgType, Payload: payload})
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.conn.WriteMessage(c.codec.MessageType(), data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// next returns the next frame, whatever its type.
+func (c *testConn) next() testFrame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatalf("no frame: %v", err)
+	}
+	var frame testFrame
+	if err := c.codec.Unmarshal(data, &frame); err != nil {
+		c.t.Fatalf("could not decode frame %q: %v", data, err)
+	}
+	return frame
+}
+
+// nextEvent returns the next frame other than presence_changed, which
+// arrives whenever someone who shares a group connects or drops.
+func (c *testConn) nextEvent() testFrame {
+	c.t.Helper()
+	for {
+		if frame := c.next(); frame.Type != TypePresenceChanged {
+			return frame
+		}
+	}
+}
+
+// expect skips frames until one of the given type arrives. An error frame
+// that was not expected fails the test.
+func (c *testConn) expect(msgType string) testFrame {
+	c.t.Helper()
+	for {
+		frame := c.next()
+		if frame.Type == msgType {
+			return frame
+		}
+		if frame.Type == TypeError {
+			c.t.Fatalf("got error %s while waiting for %s", frame.Payload, msgType)
+		}
+	}
+}
+
+// expectError waits for an error frame and returns its payload.
+func (c *testConn) expectError() ErrorPayload {
+	c.t.Helper()
+	var body ErrorPayload
+	c.expect(TypeError).decode(c, &body)
+	return body
+}
+
+func (f testFrame) decode(c *testConn, v interface{}) {
+	c.t.Helper()
+	if err := c.codec.Unmarshal(f.Payload, v); err != nil {
+		c.t.Fatalf("could not decode %s payload: %v", f.Type, err)
+	}
+}
+
+// createGroup creates a group and returns its ID.
+func (c *testConn) createGroup(name string) string {
+	c.t.Helper()
+	c.send(TypeCreateGroup, CreateGroupPayload{Name: name, JoinTag: name})
+	var view GroupView
+	c.expect(TypeGroupCreated).decode(c, &view)
+	return view.ID
+}
+
+// join joins a group and returns its view.
+func (c *testConn) join(groupID string) GroupView {
+	c.t.Helper()
+	c.send(TypeJoinGroup, JoinGroupPayload{GroupID: groupID})
+	var view GroupView
+	c.expect(TypeGroupJoined).decode(c, &view)
+	return view
+}
+
+// sendMessage sends a message and returns its ack.
+func (c *testConn) sendMessage(body SendMessagePayload) AckPayload {
+	c.t.Helper()
+	c.send(TypeSendMessage, body)
+	var ack AckPayload
+	c.expect(TypeAck).decode(c, &ack)
+	return ack
+}
@@ -0,0 +1,261 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"chat-app/server/internal/application"
+	"chat-app/server/internal/domain"
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+// WithMailbox enables store-and-forward. A user whose last connection closes
+// stays a member of their groups for the mailbox TTL, and messages sent to
+// those groups meanwhile are stored and delivered on their next authenticate.
+// Without a mailbox such a user leaves their groups right away.
+func WithMailbox(mailbox *application.MailboxService) Option {
+	return func(h *Hub) { h.mailbox = mailbox }
+}
+
+// offlineUser is a user with no open connection who is still a member of
+// their groups until the timer runs out.
+type offlineUser struct {
+	groupIDs []string
+	timer    clock.Timer
+}
+
+// goOffline keeps the memberships of a user whose last connection closed.
+func (h *Hub) goOffline(userID string, groupIDs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	user := &offlineUser{groupIDs: groupIDs}
+	h.offline[userID] = user
+	for _, groupID := range groupIDs {
+		if h.offlineMembers[groupID] == nil {
+			h.offlineMembers[groupID] = make(map[string]bool)
+		}
+		h.offlineMembers[groupID][userID] = true
+	}
+	user.timer = h.clock.AfterFunc(h.mailbox.TTL(), func() { h.expireOffline(userID, user) })
+}
+
+// comeOnlineLocked ends the offline period of a user who authenticated
+// again and returns the groups they are still a member of.
+func (h *Hub) comeOnlineLocked(userID string) []string {
+	user := h.offline[userID]
+	if user == nil {
+		return nil
+	}
+	user.timer.Stop()
+	h.forgetOfflineLocked(userID, user)
+	return user.groupIDs
+}
+
+// joinOffline adds a group to the memberships kept for an offline user, so
+// that its messages are stored for them. It does nothing for a user who is
+// not offline.
+func (h *Hub) joinOffline(userID, groupID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	user := h.offline[userID]
+	if user == nil || h.offlineMembers[groupID][userID] {
+		return
+	}
+	user.groupIDs = append(user.groupIDs, groupID)
+	if h.offlineMembers[groupID] == nil {
+		h.offlineMembers[groupID] = make(map[string]bool)
+	}
+	h.offlineMembers[groupID][userID] = true
+}
+
+func (h *Hub) forgetOfflineLocked(userID string, user *offlineUser) {
+	delete(h.offline, userID)
+	for _, groupID := range user.groupIDs {
+		delete(h.offlineMembers[groupID], userID)
+		if len(h.offlineMembers[groupID]) == 0 {
+			delete(h.offlineMembers, groupID)
+		}
+	}
+}
+
+// expireOffline removes a user who did not come back within the mailbox TTL.
+func (h *Hub) expireOffline(userID string, user *offlineUser) {
+	h.mu.Lock()
+	current := h.offline[userID] == user
+	if current {
+		h.forgetOfflineLocked(userID, user)
+	}
+	h.mu.Unlock()
+	if !current {
+		return
+	}
+
+	ctx := context.Background()
+	h.removeUser(ctx, userID, user.groupIDs)
+	if err := h.mailbox.Clear(ctx, userID); err != nil {
+		log.Printf("could not clear mailbox of user %s: %v", userID, err)
+	}
+}
+
+// depositLocked queues a copy of a message for every offline member of its
+// group and returns who they are. The caller holds gl.mu and h.mu, and
+// flushes the log's mailbox writes after releasing them.
+func (h *Hub) depositLocked(gl *groupLog, msg NewMessagePayload, seq uint64, now time.Time) []string {
+	if h.mailbox == nil || len(h.offlineMembers[msg.GroupID]) == 0 {
+		return nil
+	}
+	recipients := make([]string, 0, len(h.offlineMembers[msg.GroupID]))
+	for userID := range h.offlineMembers[msg.GroupID] {
+		recipients = append(recipients, userID)
+	}
+	entry := domain.MailboxEntry{
+		GroupID:    msg.GroupID,
+		SenderID:   msg.SenderID,
+		MessageID:  msg.MessageID,
+		ParentID:   msg.ParentID,
+		Mentions:   msg.Mentions,
+		Seq:        seq,
+		SentAt:     now,
+		Ciphertext: msg.Ciphertext,
+	}
+	gl.queueMailbox(func(ctx context.Context) {
+		for _, userID := range recipients {
+			if err := h.mailbox.Deposit(ctx, userID, entry); err != nil {
+				log.Printf("could not store message for user %s: %v", userID, err)
+			}
+		}
+	})
+	return recipients
+}
+
+// reviseMailboxLocked queues an edit to the mailbox copies of a message, or
+// a delete when ciphertext is nil. Only the users who were sent a copy are
+// touched; those who acknowledged it since have nothing left to change. The
+// caller holds gl.mu and flushes the log's mailbox writes after releasing it.
+func (h *Hub) reviseMailboxLocked(gl *groupLog, e *envelope, ciphertext []byte) {
+	if h.mailbox == nil || len(e.mailboxed) == 0 {
+		return
+	}
+	holders, messageID := e.mailboxed, e.messageID
+	if ciphertext == nil {
+		e.mailboxed = nil
+	}
+	gl.queueMailbox(func(ctx context.Context) {
+		for _, userID := range holders {
+			var err error
+			if ciphertext == nil {
+				err = h.mailbox.RemoveMessage(ctx, userID, messageID)
+			} else {
+				err = h.mailbox.EditMessage(ctx, userID, messageID, ciphertext)
+			}
+			if err != nil {
+				log.Printf("could not update stored message for user %s: %v", userID, err)
+			}
+		}
+	})
+}
+
+// mailboxPageSize caps the stored messages sent at once. A page also takes
+// at most half of the client's send buffer, so that it is not dropped or
+// cut off by the send policy; the next page follows its acknowledgement.
+const mailboxPageSize = 100
+
+// deliverMailbox starts sending the user's stored messages, oldest first.
+// Each stays in the mailbox until the client acknowledges it, so anything
+// lost on the way is delivered again on the next authenticate. Writes still
+// queued for the user's groups are flushed first, so none of their messages
+// is missed.
+func (h *Hub) deliverMailbox(ctx context.Context, client *Client, userID string, groupIDs []string) {
+	if h.mailbox == nil {
+		return
+	}
+	for _, groupID := range groupIDs {
+		h.mu.RLock()
+		gl := h.logs[groupID]
+		h.mu.RUnlock()
+		if gl != nil {
+			gl.flushMailbox()
+		}
+	}
+	h.deliverMailboxPage(ctx, client, userID)
+}
+
+// deliverMailboxPage sends the next page of the user's stored messages.
+func (h *Hub) deliverMailboxPage(ctx context.Context, client *Client, userID string) {
+	entries, err := h.mailbox.Pending(ctx, userID, h.clock.Now())
+	if err != nil {
+		log.Printf("could not read mailbox of user %s: %v", userID, err)
+		return
+	}
+	limit := min(mailboxPageSize, max(cap(client.send)/2, 1))
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	page := make([]string, len(entries))
+	for i, entry := range entries {
+		page[i] = entry.ID
+	}
+	client.startMailboxPage(page)
+	for _, entry := range entries {
+		client.enqueue(OutgoingMessage{
+			Type: TypeMailboxMessage,
+			Payload: MailboxMessagePayload{
+				EntryID:    entry.ID,
+				GroupID:    entry.GroupID,
+				SenderID:   entry.SenderID,
+				MessageID:  entry.MessageID,
+				ParentID:   entry.ParentID,
+				Mentions:   entry.Mentions,
+				Seq:        entry.Seq,
+				Timestamp:  entry.SentAt.UnixMilli(),
+				Ciphertext: entry.Ciphertext,
+				Edited:     entry.Edited,
+			},
+		})
+	}
+}
+
+// startMailboxPage records the mailbox entries about to be sent.
+func (c *Client) startMailboxPage(entryIDs []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.mailboxPage = make(map[string]bool, len(entryIDs))
+	for _, id := range entryIDs {
+		c.mailboxPage[id] = true
+	}
+}
+
+// ackMailboxPage crosses acknowledged entries off the current page and
+// reports whether that finished it.
+func (c *Client) ackMailboxPage(entryIDs []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.mailboxPage) == 0 {
+		return false
+	}
+	for _, id := range entryIDs {
+		delete(c.mailboxPage, id)
+	}
+	return len(c.mailboxPage) == 0
+}
+
+// handleMailboxAck removes delivered messages from the user's mailbox, and
+// sends the next page once the current one is acknowledged.
+func (h *Hub) handleMailboxAck(ctx context.Context, req *Request) error {
+	body := req.Body.(*MailboxAckPayload)
+	if len(body.EntryIDs) == 0 {
+		return invalidPayload("entryIds is required")
+	}
+	if h.mailbox == nil {
+		return &Error{Code: CodeRequestFailed, Message: "mailbox is disabled"}
+	}
+	if err := h.mailbox.Acknowledge(ctx, req.UserID, body.EntryIDs...); err != nil {
+		return err
+	}
+	req.Ack()
+	if req.Client.ackMailboxPage(body.EntryIDs) {
+		h.deliverMailboxPage(ctx, req.Client, req.UserID)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// maxMentions caps the members a single message may mention.
+const maxMentions = 50
+
+// checkMentions validates the members a message mentions and returns them
+// without duplicates.
+func (h *Hub) checkMentions(ctx context.Context, groupID string, mentions []string) ([]string, error) {
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+	if len(mentions) > maxMentions {
+		return nil, invalidPayload(fmt.Sprintf("a message can mention at most %d members", maxMentions))
+	}
+	group, err := h.chatService.GetGroup(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(mentions))
+	checked := make([]string, 0, len(mentions))
+	for _, userID := range mentions {
+		if seen[userID] {
+			continue
+		}
+		if !group.HasMember(userID) {
+			return nil, invalidPayload(fmt.Sprintf("mentioned user %s is not a member of the group", userID))
+		}
+		seen[userID] = true
+		checked = append(checked, userID)
+	}
+	return checked, nil
+}
+
+// notifyMentions counts the message as an unread mention for every member it
+// mentions other than the sender, and sends each of them a mentioned. Muting
+// the group does not hold it back. Members who are offline find the count in
+// their next authenticated.
+func (h *Hub) notifyMentions(ctx context.Context, msg NewMessagePayload, seq uint64) {
+	for _, userID := range msg.Mentions {
+		if userID == msg.SenderID {
+			continue
+		}
+		user, err := h.chatService.GetUser(ctx, userID)
+		if err != nil {
+			log.Printf("could not notify user %s of a mention: %v", userID, err)
+			continue
+		}
+		h.sendToUser(userID, OutgoingMessage{
+			Type: TypeMentioned,
+			Payload: MentionedPayload{
+				GroupID:   msg.GroupID,
+				MessageID: msg.MessageID,
+				Seq:       seq,
+				SenderID:  msg.SenderID,
+				Unread:    user.AddMention(msg.GroupID),
+			},
+		}, nil)
+	}
+}
+
+// handleMuteGroup turns the user's notifications for a group off or on.
+func (h *Hub) handleMuteGroup(ctx context.Context, req *Request) error {
+	body := req.Body.(*MuteGroupPayload)
+	if body.GroupID == "" {
+		return invalidPayload("groupId is required")
+	}
+	if _, err := h.chatService.SetGroupMuted(ctx, req.UserID, body.GroupID, body.Muted); err != nil {
+		return err
+	}
+	req.Ack()
+	return nil
+}
+
+// handleAckMentions clears the user's unread mentions in a group and tells
+// their other devices.
+func (h *Hub) handleAckMentions(ctx context.Context, req *Request) error {
+	body := req.Body.(*AckMentionsPayload)
+	if body.GroupID == "" {
+		return invalidPayload("groupId is required")
+	}
+	user, err := h.chatService.GetUser(ctx, req.UserID)
+	if err != nil {
+		return err
+	}
+	user.ClearMentions(body.GroupID)
+	h.sendToUser(req.UserID, OutgoingMessage{
+		Type:    TypeMentionsUpdated,
+		Payload: MentionsUpdatedPayload{GroupID: body.GroupID},
+	}, req.Client)
+	req.Ack()
+	return nil
+}
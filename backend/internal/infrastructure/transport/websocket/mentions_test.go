@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"testing"
+)
+
+// TestMentionNotifications checks that mentioned members are notified even
+// when they muted the group, and that their unread count is kept until one
+// of their devices acknowledges it.
+func TestMentionNotifications(t *testing.T) {
+	srv := newTestServer(t)
+	alice, bob := srv.connect("alice"), srv.connect("bob")
+	groupID := alice.createGroup("group")
+	bob.join(groupID)
+	bob.send(TypeMuteGroup, MuteGroupPayload{GroupID: groupID, Muted: true})
+	bob.expect(TypeAck)
+
+	for unread := 1; unread <= 2; unread++ {
+		ack := alice.sendMessage(SendMessagePayload{
+			GroupID:    groupID,
+			Ciphertext: []byte("hey"),
+			Mentions:   []string{bob.userID, bob.userID, alice.userID},
+		})
+		var mention MentionedPayload
+		bob.expect(TypeMentioned).decode(bob, &mention)
+		if mention.MessageID != ack.MessageID || mention.Seq != ack.Seq || mention.SenderID != alice.userID || mention.Unread != unread {
+			t.Fatalf("got %+v, want message %s at %d with %d unread", mention, ack.MessageID, ack.Seq, unread)
+		}
+	}
+
+	// The sender is not notified of mentioning themselves.
+	marker := bob.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("marker")})
+	if frame := alice.nextEvent(); frame.Type != TypeNewMessage || frame.Seq != marker.Seq {
+		t.Fatalf("sender got %s, want only the next message", frame.Type)
+	}
+
+	// A new device learns the count on authenticating, and clearing it
+	// there tells the others.
+	device := srv.dial()
+	device.send(TypeAuthenticate, AuthenticatePayload{Token: bob.token})
+	var auth AuthenticatedPayload
+	device.expect(TypeAuthenticated).decode(device, &auth)
+	if got := auth.UnreadMentions[groupID]; got != 2 {
+		t.Fatalf("authenticated with %d unread mentions, want 2", got)
+	}
+	device.send(TypeAckMentions, AckMentionsPayload{GroupID: groupID})
+	device.expect(TypeAck)
+	var update MentionsUpdatedPayload
+	bob.expect(TypeMentionsUpdated).decode(bob, &update)
+	if update.GroupID != groupID || update.Unread != 0 {
+		t.Fatalf("got %+v, want no unread mentions left", update)
+	}
+
+	again := srv.dial()
+	again.send(TypeAuthenticate, AuthenticatePayload{Token: bob.token})
+	var cleared AuthenticatedPayload
+	again.expect(TypeAuthenticated).decode(again, &cleared)
+	if len(cleared.UnreadMentions) != 0 {
+		t.Fatalf("authenticated with unread mentions %v after clearing them", cleared.UnreadMentions)
+	}
+}
+
+func TestMentionRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		mentions func(member, outsider string) []string
+	}{
+		{
+			name:     "not a member",
+			mentions: func(member, outsider string) []string { return []string{member, outsider} },
+		},
+		{
+			name: "too many",
+			mentions: func(member, outsider string) []string {
+				mentions := make([]string, maxMentions+1)
+				for i := range mentions {
+					mentions[i] = member
+				}
+				return mentions
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			alice, bob, carol := srv.connect("alice"), srv.connect("bob"), srv.connect("carol")
+			groupID := alice.createGroup("group")
+			bob.join(groupID)
+
+			alice.send(TypeSendMessage, SendMessagePayload{GroupID: groupID, Ciphertext: []byte("hey"), Mentions: tt.mentions(bob.userID, carol.userID)})
+			if got := alice.expectError(); got.Code != CodeInvalidPayload {
+				t.Fatalf("got %s (%s), want %s", got.Code, got.Message, CodeInvalidPayload)
+			}
+			marker := alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("marker")})
+			if frame := bob.nextEvent(); frame.Type != TypeNewMessage || frame.Seq != marker.Seq {
+				t.Fatalf("member got %s %d, want only the next message %d", frame.Type, frame.Seq, marker.Seq)
+			}
+		})
+	}
+}
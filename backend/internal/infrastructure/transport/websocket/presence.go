@@ -0,0 +1,179 @@
+package websocket
+
+import (
+	"time"
+
+	"chat-app/server/internal/domain"
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+// Presence states carried by presence_changed.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// presenceAwayAfter is how long a connected user may be idle on all of
+// their connections before they count as away.
+const presenceAwayAfter = 5 * time.Minute
+
+// presence tracks a user with at least one connection. Users without one
+// are offline and have no entry.
+type presence struct {
+	user       *domain.User
+	state      string
+	lastActive time.Time
+	timer      clock.Timer // Pending idle check, nil while away
+}
+
+// presenceOnline records that the user has a connection again.
+func (h *Hub) presenceOnline(user *domain.User) {
+	now := h.clock.Now()
+	user.Touch(now)
+
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	p := h.presence[user.ID]
+	if p == nil {
+		p = &presence{user: user, state: PresenceOffline}
+		h.presence[user.ID] = p
+	}
+	p.lastActive = now
+	h.armAwayLocked(p)
+	if p.state != PresenceOnline {
+		p.state = PresenceOnline
+		h.pushPresence(p, nil)
+	}
+}
+
+// touch records activity on one of the user's connections.
+func (h *Hub) touch(userID string) {
+	now := h.clock.Now()
+
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	p := h.presence[userID]
+	if p == nil {
+		return
+	}
+	p.lastActive = now
+	p.user.Touch(now)
+	h.armAwayLocked(p)
+	if p.state == PresenceAway {
+		p.state = PresenceOnline
+		h.pushPresence(p, nil)
+	}
+}
+
+// presenceOffline records that the user's last connection closed. groupIDs
+// are the groups the user was subscribed to, who are told.
+func (h *Hub) presenceOffline(userID string, groupIDs []string) {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	p := h.presence[userID]
+	if p == nil {
+		return
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	delete(h.presence, userID)
+	p.state = PresenceOffline
+	h.pushPresence(p, groupIDs)
+}
+
+// armAwayLocked makes sure an idle check is pending. Activity does not
+// restart the timer; the check itself waits out whatever idle time is left.
+func (h *Hub) armAwayLocked(p *presence) {
+	if p.timer == nil {
+		h.scheduleAwayLocked(p, presenceAwayAfter)
+	}
+}
+
+func (h *Hub) scheduleAwayLocked(p *presence, d time.Duration) {
+	var timer clock.Timer
+	timer = h.clock.AfterFunc(d, func() { h.checkAway(p, timer) })
+	p.timer = timer
+}
+
+// checkAway marks an idle user as away.
+func (h *Hub) checkAway(p *presence, timer clock.Timer) {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	if p.timer != timer || h.presence[p.user.ID] != p {
+		return
+	}
+	p.timer = nil
+	if idle := h.clock.Now().Sub(p.lastActive); idle < presenceAwayAfter {
+		h.scheduleAwayLocked(p, presenceAwayAfter-idle)
+		return
+	}
+	p.state = PresenceAway
+	h.pushPresence(p, nil)
+}
+
+// presenceOf returns what others may see of the user's presence.
+func (h *Hub) presenceOf(user *domain.User) (string, time.Time) {
+	if user.GetPrivacy().HidePresence {
+		return PresenceOffline, time.Time{}
+	}
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	if p := h.presence[user.ID]; p != nil {
+		return p.state, user.GetLastSeen()
+	}
+	return PresenceOffline, user.GetLastSeen()
+}
+
+// pushPresence tells everyone who shares a group with the user about their
+// current state, unless the user hides their presence. A nil groupIDs means
+// the groups the user is subscribed to. The caller holds h.presenceMu.
+func (h *Hub) pushPresence(p *presence, groupIDs []string) {
+	if p.user.GetPrivacy().HidePresence {
+		return
+	}
+	h.announcePresence(p.user.ID, p.state, p.user.GetLastSeen(), groupIDs)
+}
+
+// announcePresence sends a presence_changed to every connection that shares
+// a group with the user, once per connection.
+func (h *Hub) announcePresence(userID, state string, lastSeen time.Time, groupIDs []string) {
+	payload := PresencePayload{UserID: userID, State: state}
+	if !lastSeen.IsZero() {
+		payload.LastSeen = lastSeen.UnixMilli()
+	}
+	frame := newSharedFrame(OutgoingMessage{Type: TypePresenceChanged, Payload: payload})
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if groupIDs == nil {
+		for client := range h.clients[userID] {
+			groupIDs = h.subscriptionsLocked(client)
+			break
+		}
+	}
+	seen := make(map[*Client]bool)
+	for _, groupID := range groupIDs {
+		for member := range h.groups[groupID] {
+			if member.UserID != userID && !seen[member] {
+				seen[member] = true
+				member.enqueueShared(frame)
+			}
+		}
+	}
+}
+
+// presenceVisibilityChanged announces a user who just hid their presence as
+// offline, or one who just stopped hiding it with their real state.
+func (h *Hub) presenceVisibilityChanged(user *domain.User, hidden bool) {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+	if hidden {
+		h.announcePresence(user.ID, PresenceOffline, time.Time{}, nil)
+		return
+	}
+	if p := h.presence[user.ID]; p != nil {
+		h.pushPresence(p, nil)
+	}
+}
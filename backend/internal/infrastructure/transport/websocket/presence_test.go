@@ -0,0 +1,147 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+// presenceBefore returns the presence_changed events about a user that
+// arrive ahead of the new_message with the given sequence number.
+func (c *testConn) presenceBefore(userID string, seq uint64) []PresencePayload {
+	c.t.Helper()
+	var events []PresencePayload
+	for {
+		frame := c.next()
+		switch frame.Type {
+		case TypePresenceChanged:
+			var event PresencePayload
+			frame.decode(c, &event)
+			if event.UserID == userID {
+				events = append(events, event)
+			}
+		case TypeNewMessage:
+			if frame.Seq == seq {
+				return events
+			}
+		case TypeError:
+			c.t.Fatalf("got error %s", frame.Payload)
+		}
+	}
+}
+
+// expectPresence waits for a presence_changed about the user.
+func (c *testConn) expectPresence(userID string) PresencePayload {
+	c.t.Helper()
+	for {
+		var event PresencePayload
+		c.expect(TypePresenceChanged).decode(c, &event)
+		if event.UserID == userID {
+			return event
+		}
+	}
+}
+
+// waitConnections waits until the user has n connections.
+func (s *testServer) waitConnections(userID string, n int) {
+	s.t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		s.hub.mu.RLock()
+		got := len(s.hub.clients[userID])
+		s.hub.mu.RUnlock()
+		if got == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("user %s has %d connections, want %d", userID, got, n)
+		}
+	}
+}
+
+// TestPresenceStates follows a user with two connections from online to
+// away, back online and offline, as seen by another member.
+func TestPresenceStates(t *testing.T) {
+	srv := newTestServer(t, WithResume(ResumeConfig{}))
+	alice, bob, carol := srv.connect("alice"), srv.connect("bob"), srv.connect("carol")
+	groupID := alice.createGroup("group")
+	bob.join(groupID)
+	carol.join(groupID)
+	mark := func() uint64 {
+		t.Helper()
+		return carol.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("marker")}).Seq
+	}
+	states := func(events []PresencePayload) []string {
+		var states []string
+		for _, event := range events {
+			states = append(states, event.State)
+		}
+		return states
+	}
+
+	second := srv.reconnect(alice.token)
+	if got := bob.presenceBefore(alice.userID, mark()); len(got) != 0 {
+		t.Fatalf("a second connection announced %v", states(got))
+	}
+
+	srv.clock.Advance(presenceAwayAfter)
+	if got := states(bob.presenceBefore(alice.userID, mark())); len(got) != 1 || got[0] != PresenceAway {
+		t.Fatalf("idle user announced %v, want [away]", got)
+	}
+
+	second.send(TypeTypingStop, TypingPayload{GroupID: groupID})
+	second.expect(TypeAck)
+	if got := states(bob.presenceBefore(alice.userID, mark())); len(got) != 1 || got[0] != PresenceOnline {
+		t.Fatalf("active user announced %v, want [online]", got)
+	}
+
+	second.conn.Close()
+	srv.waitConnections(alice.userID, 1)
+	if got := bob.presenceBefore(alice.userID, mark()); len(got) != 0 {
+		t.Fatalf("closing one of two connections announced %v", states(got))
+	}
+
+	alice.conn.Close()
+	event := bob.expectPresence(alice.userID)
+	if event.State != PresenceOffline || event.LastSeen == 0 {
+		t.Fatalf("got %+v, want offline with a last seen time", event)
+	}
+}
+
+// TestHidePresence checks that a user who hides their presence looks
+// offline, with no last seen time, until they stop hiding it.
+func TestHidePresence(t *testing.T) {
+	srv := newTestServer(t)
+	alice, bob := srv.connect("alice"), srv.connect("bob")
+	groupID := alice.createGroup("group")
+	bob.join(groupID)
+	hide := func(hidden bool) {
+		t.Helper()
+		alice.send(TypeUpdatePrivacy, UpdatePrivacyPayload{HidePresence: &hidden})
+		var settings PrivacyView
+		alice.expect(TypePrivacyUpdated).decode(alice, &settings)
+		if settings.HidePresence != hidden {
+			t.Fatalf("hidePresence is %v, want %v", settings.HidePresence, hidden)
+		}
+	}
+
+	hide(true)
+	if event := bob.expectPresence(alice.userID); event.State != PresenceOffline || event.LastSeen != 0 {
+		t.Fatalf("hiding announced %+v, want offline with no last seen time", event)
+	}
+	carol := srv.connect("carol")
+	for _, member := range carol.join(groupID).Members {
+		if member.ID == alice.userID && (member.Presence != PresenceOffline || member.LastSeen != 0) {
+			t.Fatalf("group view shows %s last seen at %d, want offline", member.Presence, member.LastSeen)
+		}
+	}
+
+	srv.clock.Advance(presenceAwayAfter)
+	marker := carol.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("marker")})
+	if got := bob.presenceBefore(alice.userID, marker.Seq); len(got) != 0 {
+		t.Fatalf("hidden user announced %+v", got)
+	}
+
+	hide(false)
+	if event := bob.expectPresence(alice.userID); event.State != PresenceOnline || event.LastSeen == 0 {
+		t.Fatalf("unhiding announced %+v, want online with a last seen time", event)
+	}
+}
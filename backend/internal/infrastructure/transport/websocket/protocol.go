@@ -1,13 +1,609 @@
 package websocket
 
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// Protocol versions this server can speak. A client announces the versions it
+// supports in its first frame, and the highest one both sides know is used for
+// the rest of the connection.
+const (
+	MinProtocolVersion = 1
+	MaxProtocolVersion = 1
+)
+
+// Incoming frame types.
+const (
+	TypeHello             = "hello"
+	TypeAuthenticate      = "authenticate"
+	TypeResume            = "resume"
+	TypeMailboxAck        = "mailbox_ack"
+	TypeCreateGroup       = "create_group"
+	TypeJoinGroup         = "join_group"
+	TypeLeaveGroup        = "leave_group"
+	TypeSendMessage       = "send_message"
+	TypeEditMessage       = "edit_message"
+	TypeDeleteMessage     = "delete_message"
+	TypeAddReaction       = "add_reaction"
+	TypeRemoveReaction    = "remove_reaction"
+	TypeSyncRequest       = "sync_request"
+	TypeFetchHistory      = "fetch_history"
+	TypeFetchThread       = "fetch_thread"
+	TypeDelivered         = "delivered"
+	TypeRead              = "read"
+	TypeTypingStart       = "typing_start"
+	TypeTypingStop        = "typing_stop"
+	TypeKeyExchangeOffer  = "key_exchange_offer"
+	TypeKeyExchangeAnswer = "key_exchange_answer"
+	TypeUpdateProfile     = "update_profile"
+	TypeUpdatePrivacy     = "update_privacy"
+	TypeMuteGroup         = "mute_group"
+	TypeAckMentions       = "ack_mentions"
+	TypeChunkStart        = "chunk_start"
+	TypeChunk             = "chunk"
+	TypeChunkEnd          = "chunk_end"
+)
+
+// Outgoing frame types.
+const (
+	TypeWelcome             = "welcome"
+	TypeAuthenticated       = "authenticated"
+	TypeResumed             = "resumed"
+	TypeMailboxMessage      = "mailbox_message"
+	TypeGroupCreated        = "group_created"
+	TypeGroupJoined         = "group_joined"
+	TypeDirectOpened        = "direct_opened"
+	TypeGroupLeft           = "group_left"
+	TypeMemberJoined        = "member_joined"
+	TypeMemberLeft          = "member_left"
+	TypeMemberUpdated       = "member_updated"
+	TypeNewMessage          = "new_message"
+	TypeMessageEdited       = "message_edited"
+	TypeMessageDeleted      = "message_deleted"
+	TypeReactionsUpdated    = "reactions_updated"
+	TypeSyncResponse        = "sync_response"
+	TypeHistory             = "history"
+	TypeThread              = "thread"
+	TypeThreadReply         = "thread_reply"
+	TypeMentioned           = "mentioned"
+	TypeMentionsUpdated     = "mentions_updated"
+	TypeReceiptsUpdated     = "receipts_updated"
+	TypeTypingStarted       = "typing_started"
+	TypeTypingStopped       = "typing_stopped"
+	TypeProfileUpdated      = "profile_updated"
+	TypePrivacyUpdated      = "privacy_updated"
+	TypePresenceChanged     = "presence_changed"
+	TypeKeyExchangeComplete = "key_exchange_complete"
+	TypeMessagesDropped     = "messages_dropped"
+	TypeRateLimitWarning    = "rate_limit_warning"
+	TypeRateLimitMuted      = "rate_limit_muted"
+	TypeAck                 = "ack"
+	TypeError               = "error"
+)
+
 // IncomingMessage represents a message received from a client.
+// ID is an optional client-chosen correlation ID that the server echoes on
+// the reply, ack or error for this frame.
 type IncomingMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+	ID      string     `json:"id,omitempty"`
+	Type    string     `json:"type"`
+	Payload RawPayload `json:"payload,omitempty"`
 }
 
 // OutgoingMessage represents a message sent to a client.
+// Payload holds one of the outgoing payload structs below. ID is set only on
+// direct replies to a frame that carried an ID. Seq and Timestamp are set only
+// on group broadcasts: Seq increases by one with every broadcast to the group,
+// so a client can spot gaps, and Timestamp is the server time in Unix
+// milliseconds.
 type OutgoingMessage struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
+	ID        string      `json:"id,omitempty"`
+	Type      string      `json:"type"`
+	Seq       uint64      `json:"seq,omitempty"`
+	Timestamp int64       `json:"timestamp,omitempty"`
+	Payload   interface{} `json:"payload"`
+}
+
+// HelloPayload is the first frame on a connection.
+type HelloPayload struct {
+	Versions []int `json:"versions"`
+}
+
+// AuthenticatePayload authenticates with a token, or requests a new
+// anonymous identity when Token is empty.
+type AuthenticatePayload struct {
+	Token       string `json:"token,omitempty"`
+	DisplayName string `json:"displayName"`
+	PublicKey   string `json:"publicKey"`
+}
+
+// ResumePayload takes over a dropped session in place of authenticate.
+type ResumePayload struct {
+	ResumeToken string `json:"resumeToken"`
+}
+
+// MailboxAckPayload confirms that mailbox messages were delivered, so the
+// server can forget them. Acknowledging a whole page of mailbox messages
+// brings the next one.
+type MailboxAckPayload struct {
+	EntryIDs []string `json:"entryIds"`
+}
+
+type CreateGroupPayload struct {
+	Name    string `json:"name"`
+	JoinTag string `json:"joinTag"`
+}
+
+type JoinGroupPayload struct {
+	GroupID string `json:"groupId"`
+}
+
+type LeaveGroupPayload struct {
+	GroupID string `json:"groupId"`
+}
+
+// SendMessagePayload carries an end-to-end encrypted message. The server
+// relays the ciphertext without inspecting it. Byte slices are base64 in
+// JSON and raw bytes in MessagePack. ClientMessageID makes the send safe to
+// retry: a second send with the same ID from the same user is acked again
+// instead of being broadcast. ParentID makes the message a reply in the
+// thread of an earlier message; a reply to a reply joins the same thread.
+// RecipientID sends to the direct conversation with that user in place of
+// GroupID, opening it on first use. Mentions lists the members the message
+// mentions; the server cannot see the text, so the client names them.
+type SendMessagePayload struct {
+	GroupID         string   `json:"groupId,omitempty"`
+	RecipientID     string   `json:"recipientId,omitempty"`
+	Ciphertext      []byte   `json:"ciphertext"`
+	ClientMessageID string   `json:"clientMessageId,omitempty"`
+	ParentID        string   `json:"parentId,omitempty"`
+	Mentions        []string `json:"mentions,omitempty"`
+}
+
+// EditMessagePayload replaces the ciphertext of one of the user's own
+// messages, identified by the messageId it was acked with.
+type EditMessagePayload struct {
+	GroupID    string `json:"groupId"`
+	MessageID  string `json:"messageId"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// DeleteMessagePayload retracts a message. Senders may delete their own
+// messages and moderators anyone's.
+type DeleteMessagePayload struct {
+	GroupID   string `json:"groupId"`
+	MessageID string `json:"messageId"`
+}
+
+// FetchThreadPayload asks for a page of the replies to a message, like
+// fetch_history does for the main timeline.
+type FetchThreadPayload struct {
+	GroupID   string `json:"groupId"`
+	ParentID  string `json:"parentId"`
+	BeforeSeq uint64 `json:"beforeSeq,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+// ReactionPayload adds or removes the user's reaction to a message.
+type ReactionPayload struct {
+	GroupID   string `json:"groupId"`
+	MessageID string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+}
+
+// SyncRequestPayload asks for a group's broadcasts from FromSeq to ToSeq,
+// inclusive. A zero ToSeq means up to the latest one.
+type SyncRequestPayload struct {
+	GroupID string `json:"groupId"`
+	FromSeq uint64 `json:"fromSeq"`
+	ToSeq   uint64 `json:"toSeq,omitempty"`
+}
+
+// FetchHistoryPayload asks for a page of a group's recent messages older
+// than BeforeSeq, or the newest ones when BeforeSeq is zero.
+type FetchHistoryPayload struct {
+	GroupID   string `json:"groupId"`
+	BeforeSeq uint64 `json:"beforeSeq,omitempty"`
+	Limit     int    `json:"limit,omitempty"`
+}
+
+// ReceiptPayload reports that every message of a group up to Seq was
+// delivered to, or read by, the user.
+type ReceiptPayload struct {
+	GroupID string `json:"groupId"`
+	Seq     uint64 `json:"seq"`
+}
+
+// TypingPayload starts or stops the user's typing indicator in a group.
+type TypingPayload struct {
+	GroupID string `json:"groupId"`
+}
+
+// KeyExchangePayload carries one step of a key exchange to a single member.
+// Without GroupID it goes through the direct conversation with the target.
+type KeyExchangePayload struct {
+	GroupID      string `json:"groupId,omitempty"`
+	TargetUserID string `json:"targetUserId"`
+	Data         []byte `json:"data"`
+}
+
+type UpdateProfilePayload struct {
+	DisplayName       string `json:"displayName,omitempty"`
+	ProfilePictureURL string `json:"profilePictureUrl,omitempty"`
+}
+
+// MuteGroupPayload turns the user's notifications for a group off or back
+// on. Messages keep arriving; mentions still notify.
+type MuteGroupPayload struct {
+	GroupID string `json:"groupId"`
+	Muted   bool   `json:"muted"`
+}
+
+// AckMentionsPayload marks the user's mentions in a group as read.
+type AckMentionsPayload struct {
+	GroupID string `json:"groupId"`
+}
+
+// UpdatePrivacyPayload changes privacy settings. Fields that are left out
+// keep their current value.
+type UpdatePrivacyPayload struct {
+	HidePresence    *bool `json:"hidePresence,omitempty"`
+	DisableReceipts *bool `json:"disableReceipts,omitempty"`
+}
+
+// ChunkStartPayload opens an upload of a payload too large for one frame.
+// Type is the frame type the payload is for and Size its length in bytes, in
+// the connection's wire format.
+type ChunkStartPayload struct {
+	UploadID string `json:"uploadId"`
+	Type     string `json:"type"`
+	Size     int    `json:"size"`
+}
+
+// ChunkPayload carries the next piece of an upload.
+type ChunkPayload struct {
+	UploadID string `json:"uploadId"`
+	Data     []byte `json:"data"`
+}
+
+// ChunkEndPayload completes an upload. The reassembled payload is handled as
+// a frame of the upload's type with chunk_end's correlation ID.
+type ChunkEndPayload struct {
+	UploadID string `json:"uploadId"`
+}
+
+// WelcomePayload answers a hello with the negotiated protocol version.
+// MaxFrameSize is the largest frame the server reads; larger payloads must be
+// sent in chunks.
+type WelcomePayload struct {
+	Version      int `json:"version"`
+	MaxFrameSize int `json:"maxFrameSize"`
+}
+
+// AuthenticatedPayload confirms authentication. Groups lists the groups the
+// user already belongs to through their other connections. ResumeToken lets
+// this connection's session be resumed after a drop, and is empty when
+// resumption is disabled. UnreadMentions maps group IDs to the user's
+// unacknowledged mentions there.
+type AuthenticatedPayload struct {
+	Token          string         `json:"token"`
+	ResumeToken    string         `json:"resumeToken,omitempty"`
+	User           UserView       `json:"user"`
+	Groups         []GroupView    `json:"groups"`
+	UnreadMentions map[string]int `json:"unreadMentions,omitempty"`
+}
+
+// ResumedPayload confirms a resumed session. Replayed frames follow right
+// after it; Dropped counts frames that did not fit in the session buffer.
+// The resume token is single use, so ResumeToken replaces the old one.
+type ResumedPayload struct {
+	ResumeToken string   `json:"resumeToken"`
+	UserID      string   `json:"userId"`
+	Groups      []string `json:"groups"`
+	Replayed    int      `json:"replayed"`
+	Dropped     int      `json:"dropped"`
+}
+
+// UserView is the public representation of a user. Presence and LastSeen
+// are only filled in within group member lists.
+type UserView struct {
+	ID                string `json:"id"`
+	DisplayName       string `json:"displayName"`
+	ProfilePictureURL string `json:"profilePictureUrl,omitempty"`
+	PublicKey         string `json:"publicKey"`
+	Presence          string `json:"presence,omitempty"`
+	LastSeen          int64  `json:"lastSeen,omitempty"` // Unix milliseconds
+}
+
+// PrivacyView is a user's privacy settings as shown to that user.
+type PrivacyView struct {
+	HidePresence    bool `json:"hidePresence"`
+	DisableReceipts bool `json:"disableReceipts"`
+}
+
+// PresencePayload announces a change in a user's presence to the people who
+// share a group with them. LastSeen is in Unix milliseconds.
+type PresencePayload struct {
+	UserID   string `json:"userId"`
+	State    string `json:"state"` // online, away or offline
+	LastSeen int64  `json:"lastSeen,omitempty"`
+}
+
+// GroupView is the representation of a group sent to its members. A direct
+// conversation has no name, join tag or owner; clients name it after the
+// other member.
+type GroupView struct {
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	Direct            bool       `json:"direct,omitempty"`
+	JoinTag           string     `json:"joinTag"`
+	ProfilePictureURL string     `json:"profilePictureUrl,omitempty"`
+	OwnerID           string     `json:"ownerId"`
+	Members           []UserView `json:"members"`
+	LastSeq           uint64     `json:"lastSeq"` // Sequence number of the latest broadcast
+}
+
+// MailboxMessagePayload is a message that was stored while the user was
+// offline. The client acknowledges it with mailbox_ack and EntryID. Stored
+// messages are sent in pages, oldest first.
+type MailboxMessagePayload struct {
+	EntryID    string   `json:"entryId"`
+	GroupID    string   `json:"groupId"`
+	SenderID   string   `json:"senderId"`
+	MessageID  string   `json:"messageId"`
+	ParentID   string   `json:"parentId,omitempty"`
+	Mentions   []string `json:"mentions,omitempty"`
+	Seq        uint64   `json:"seq"`
+	Timestamp  int64    `json:"timestamp"`
+	Ciphertext []byte   `json:"ciphertext"`
+	Edited     bool     `json:"edited,omitempty"`
+}
+
+type GroupLeftPayload struct {
+	GroupID string `json:"groupId"`
+}
+
+type MemberJoinedPayload struct {
+	GroupID string   `json:"groupId"`
+	User    UserView `json:"user"`
+}
+
+type MemberLeftPayload struct {
+	GroupID    string `json:"groupId"`
+	UserID     string `json:"userId"`
+	NewOwnerID string `json:"newOwnerId,omitempty"`
+}
+
+type MemberUpdatedPayload struct {
+	GroupID string   `json:"groupId"`
+	User    UserView `json:"user"`
+}
+
+// NewMessagePayload is a chat message as broadcast to the group. MessageID
+// is assigned by the server and names the message in later edits and
+// deletes. ParentID is set on thread replies, and Mentions lists the members
+// the message mentions. A message replayed by sync_request after it was
+// changed carries its current ciphertext and Edited, or Deleted and no
+// ciphertext.
+type NewMessagePayload struct {
+	GroupID    string   `json:"groupId"`
+	SenderID   string   `json:"senderId"`
+	MessageID  string   `json:"messageId"`
+	ParentID   string   `json:"parentId,omitempty"`
+	Mentions   []string `json:"mentions,omitempty"`
+	Ciphertext []byte   `json:"ciphertext,omitempty"`
+	Edited     bool     `json:"edited,omitempty"`
+	Deleted    bool     `json:"deleted,omitempty"`
+}
+
+// MessageEditedPayload tells the group that a message has a new ciphertext.
+// MessageSeq is the sequence number the message was first broadcast with.
+type MessageEditedPayload struct {
+	GroupID    string `json:"groupId"`
+	MessageID  string `json:"messageId"`
+	MessageSeq uint64 `json:"messageSeq"`
+	SenderID   string `json:"senderId"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// MessageDeletedPayload tells the group that a message was retracted.
+// DeletedBy is the sender or, for moderation, someone else.
+type MessageDeletedPayload struct {
+	GroupID    string `json:"groupId"`
+	MessageID  string `json:"messageId"`
+	MessageSeq uint64 `json:"messageSeq"`
+	DeletedBy  string `json:"deletedBy"`
+}
+
+// SyncResponsePayload follows the frames resent for a sync_request. Count
+// frames from FromSeq to ToSeq were resent; anything below OldestSeq is no
+// longer retained and cannot be recovered.
+type SyncResponsePayload struct {
+	GroupID   string `json:"groupId"`
+	FromSeq   uint64 `json:"fromSeq"`
+	ToSeq     uint64 `json:"toSeq"`
+	Count     int    `json:"count"`
+	OldestSeq uint64 `json:"oldestSeq"`
+	LastSeq   uint64 `json:"lastSeq"`
+}
+
+// HistoryPayload is one page of a group's main timeline, oldest first.
+// Thread replies are left out. HasMore means older messages can be fetched
+// with BeforeSeq set to the first Seq.
+type HistoryPayload struct {
+	GroupID  string           `json:"groupId"`
+	Messages []HistoryMessage `json:"messages"`
+	HasMore  bool             `json:"hasMore"`
+}
+
+// HistoryMessage is a retained message with its current reactions. Seq and
+// Timestamp match the new_message broadcast it was delivered in. A deleted
+// message stays as a tombstone without ciphertext, so clients that have it
+// can drop it. Messages with replies carry their thread's ReplyCount and
+// LastReplyAt, in Unix milliseconds.
+type HistoryMessage struct {
+	Seq         uint64          `json:"seq"`
+	Timestamp   int64           `json:"timestamp"`
+	SenderID    string          `json:"senderId"`
+	MessageID   string          `json:"messageId"`
+	ParentID    string          `json:"parentId,omitempty"`
+	Mentions    []string        `json:"mentions,omitempty"`
+	Ciphertext  []byte          `json:"ciphertext,omitempty"`
+	Edited      bool            `json:"edited,omitempty"`
+	Deleted     bool            `json:"deleted,omitempty"`
+	Reactions   []ReactionTally `json:"reactions,omitempty"`
+	ReplyCount  int             `json:"replyCount,omitempty"`
+	LastReplyAt int64           `json:"lastReplyAt,omitempty"`
+}
+
+// ThreadPayload is one page of the replies to a message, oldest first.
+type ThreadPayload struct {
+	GroupID     string           `json:"groupId"`
+	ParentID    string           `json:"parentId"`
+	ReplyCount  int              `json:"replyCount"`
+	LastReplyAt int64            `json:"lastReplyAt,omitempty"`
+	Messages    []HistoryMessage `json:"messages"`
+	HasMore     bool             `json:"hasMore"`
+}
+
+// ThreadReplyPayload notifies the participants of a thread, those who
+// started it or replied to it, of a new reply. The reply itself arrives as a
+// new_message like any other. Participants who muted the group or are
+// mentioned in the reply are not notified this way.
+type ThreadReplyPayload struct {
+	GroupID     string `json:"groupId"`
+	ParentID    string `json:"parentId"`
+	MessageID   string `json:"messageId"`
+	SenderID    string `json:"senderId"`
+	ReplyCount  int    `json:"replyCount"`
+	LastReplyAt int64  `json:"lastReplyAt"`
+}
+
+// ReactionsUpdatedPayload carries the full reaction tally of a message after
+// a change. MessageSeq is the sequence number the message was first
+// broadcast with.
+type ReactionsUpdatedPayload struct {
+	GroupID    string          `json:"groupId"`
+	MessageID  string          `json:"messageId"`
+	MessageSeq uint64          `json:"messageSeq"`
+	Reactions  []ReactionTally `json:"reactions"`
+}
+
+// ReactionTally is one emoji on a message and who reacted with it.
+type ReactionTally struct {
+	Emoji   string   `json:"emoji"`
+	Count   int      `json:"count"`
+	UserIDs []string `json:"userIds"`
+}
+
+// MentionedPayload notifies a user that a message mentions them. It is sent
+// even if the user muted the group. Unread is the user's count of
+// unacknowledged mentions in the group, including this one.
+type MentionedPayload struct {
+	GroupID   string `json:"groupId"`
+	MessageID string `json:"messageId"`
+	Seq       uint64 `json:"seq"`
+	SenderID  string `json:"senderId"`
+	Unread    int    `json:"unread"`
+}
+
+// MentionsUpdatedPayload tells the user's devices that the unread mention
+// count of a group changed, for example because another device cleared it.
+type MentionsUpdatedPayload struct {
+	GroupID string `json:"groupId"`
+	Unread  int    `json:"unread"`
+}
+
+// ReceiptsUpdatedPayload tells a sender how far their recent messages in a
+// group have got, batched over all receipts since the last update.
+type ReceiptsUpdatedPayload struct {
+	GroupID  string         `json:"groupId"`
+	Messages []ReceiptCount `json:"messages"`
+}
+
+// ReceiptCount is the receipt tally of one message, such as read by Read of
+// Total recipients. Recipients who opted out of receipts are not counted.
+type ReceiptCount struct {
+	Seq       uint64 `json:"seq"`
+	Delivered int    `json:"delivered"`
+	Read      int    `json:"read"`
+	Total     int    `json:"total"`
+}
+
+// TypingEventPayload tells members that someone started or stopped typing.
+// A typing_stopped follows on its own if the typist goes quiet.
+type TypingEventPayload struct {
+	GroupID string `json:"groupId"`
+	UserID  string `json:"userId"`
+}
+
+// KeyExchangeForwardPayload is a key exchange step as delivered to its target.
+// NextType is the frame type the recipient should reply with.
+type KeyExchangeForwardPayload struct {
+	GroupID    string `json:"groupId"`
+	FromUserID string `json:"fromUserId"`
+	Data       []byte `json:"data"`
+	NextType   string `json:"nextType"`
+}
+
+// MessagesDroppedPayload tells a slow client how many messages it missed
+// because its send buffer was full.
+type MessagesDroppedPayload struct {
+	Count int `json:"count"`
+}
+
+// RateLimitWarningPayload tells a client that it is sending frames faster
+// than it is allowed to. MuteAfter is how many violations within the window
+// lead to a mute, or zero if they never do.
+type RateLimitWarningPayload struct {
+	Violations int `json:"violations"`
+	MuteAfter  int `json:"muteAfter,omitempty"`
+}
+
+// RateLimitMutedPayload tells a user's connections that every frame they
+// send is rejected until the given time, in Unix milliseconds.
+type RateLimitMutedPayload struct {
+	Until int64 `json:"until"`
+}
+
+// AckPayload confirms that a frame without a dedicated reply was processed.
+type AckPayload struct {
+	Type      string `json:"type"`                // Type of the acknowledged frame
+	Seq       uint64 `json:"seq,omitempty"`       // Sequence number of an accepted group message
+	MessageID string `json:"messageId,omitempty"` // Server ID of a sent message
+	Duplicate bool   `json:"duplicate,omitempty"` // The frame repeated an earlier one and was not sent again
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Type    string `json:"type,omitempty"` // Type of the frame that failed
+}
+
+// decodeStrict decodes a single JSON value into v, rejecting unknown fields
+// and trailing data.
+func decodeStrict(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("unexpected data after payload")
+	}
+	return nil
+}
+
+// negotiateVersion picks the highest version supported by both sides, or 0.
+func negotiateVersion(clientVersions []int) int {
+	best := 0
+	for _, v := range clientVersions {
+		if v >= MinProtocolVersion && v <= MaxProtocolVersion && v > best {
+			best = v
+		}
+	}
+	return best
 }
@@ -0,0 +1,31 @@
+package websocket
+
+import (
+	"testing"
+)
+
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{name: "single value", data: `{"name":"a","joinTag":"b"}`},
+		{name: "trailing whitespace", data: "{\"name\":\"a\"} \n"},
+		{name: "unknown field", data: `{"name":"a","owner":"b"}`, wantErr: true},
+		{name: "trailing brace", data: `{"name":"a"}}`, wantErr: true},
+		{name: "trailing bracket", data: `{"name":"a"}]`, wantErr: true},
+		{name: "second value", data: `{"name":"a"}{"name":"b"}`, wantErr: true},
+		{name: "trailing scalar", data: `{"name":"a"} 1`, wantErr: true},
+		{name: "truncated", data: `{"name":"a"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v CreateGroupPayload
+			err := decodeStrict([]byte(tt.data), &v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeStrict(%s) = %v, want error %v", tt.data, err, tt.wantErr)
+			}
+		})
+	}
+}
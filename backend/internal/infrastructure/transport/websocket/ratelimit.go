@@ -0,0 +1,255 @@
+package websocket
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// CloseRateLimited is the close code sent to a client that is disconnected
+// because it kept sending frames after it was rate limited.
+const CloseRateLimited = 4029
+
+// Rate is a token bucket that holds Burst frames and refills at PerSecond. A
+// PerSecond of zero leaves the bucket unlimited.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// RateLimitConfig limits the frames the hub reads from clients. Every
+// connection has a Connection bucket for all of its frames, and every user a
+// bucket per frame type that all of their connections share; until a
+// connection authenticates it uses buckets of its own. A frame that finds a
+// bucket empty is rejected and counts as a violation.
+//
+// Violations within Window escalate: the WarnAfter-th brings a
+// rate_limit_warning, the MuteAfter-th rejects every frame for MuteFor, and
+// the DisconnectAfter-th closes the user's connections with CloseRateLimited.
+// A zero threshold skips that step.
+type RateLimitConfig struct {
+	Connection Rate
+	Default    Rate            // Per user, for frame types without their own rate
+	Types      map[string]Rate // Map frame type to its per-user rate
+
+	Window          time.Duration
+	WarnAfter       int
+	MuteAfter       int
+	MuteFor         time.Duration
+	DisconnectAfter int
+}
+
+// DefaultRateLimitConfig is strict about creating and joining groups, which
+// touch shared state, and loose about typing indicators, which clients send
+// in bursts while the user types.
+var DefaultRateLimitConfig = RateLimitConfig{
+	Connection: Rate{PerSecond: 30, Burst: 60},
+	Default:    Rate{PerSecond: 5, Burst: 20},
+	Types: map[string]Rate{
+		TypeAuthenticate:  {PerSecond: 1, Burst: 5},
+		TypeResume:        {PerSecond: 1, Burst: 5},
+		TypeCreateGroup:   {PerSecond: 0.2, Burst: 3},
+		TypeJoinGroup:     {PerSecond: 0.5, Burst: 5},
+		TypeSendMessage:   {PerSecond: 10, Burst: 20},
+		TypeEditMessage:   {PerSecond: 2, Burst: 10},
+		TypeDeleteMessage: {PerSecond: 2, Burst: 10},
+		TypeFetchHistory:  {PerSecond: 2, Burst: 10},
+		TypeFetchThread:   {PerSecond: 2, Burst: 10},
+		TypeSyncRequest:   {PerSecond: 2, Burst: 10},
+		TypeDelivered:     {PerSecond: 10, Burst: 20},
+		TypeRead:          {PerSecond: 10, Burst: 20},
+		TypeTypingStart:   {PerSecond: 10, Burst: 30},
+		TypeTypingStop:    {PerSecond: 10, Burst: 30},
+		TypeUpdateProfile: {PerSecond: 1, Burst: 5},
+		TypeUpdatePrivacy: {PerSecond: 1, Burst: 5},
+		TypeMuteGroup:     {PerSecond: 1, Burst: 5},
+		TypeChunk:         {PerSecond: 20, Burst: 40},
+	},
+	Window:          time.Minute,
+	WarnAfter:       1,
+	MuteAfter:       20,
+	MuteFor:         30 * time.Second,
+	DisconnectAfter: 60,
+}
+
+// WithRateLimits configures the limits on frames read from clients.
+func WithRateLimits(config RateLimitConfig) Option {
+	return func(h *Hub) { h.limiter = newRateLimiter(config) }
+}
+
+// rateAction is what happens to a frame after the limits were checked.
+type rateAction int
+
+const (
+	rateAllowed rateAction = iota
+	rateRejected
+	rateWarned
+	rateMuted
+	rateDisconnected
+)
+
+type rateVerdict struct {
+	action     rateAction
+	violations int
+	mutedUntil time.Time
+}
+
+// rateState is the rate limiting state of a user, or of a connection that
+// has not authenticated.
+type rateState struct {
+	buckets    map[string]*tokenBucket // Map frame type to its bucket
+	violations []time.Time             // Within the window, oldest first
+	mutedUntil time.Time
+}
+
+// rateLimiter applies a RateLimitConfig. Its mutex also guards the rate
+// limiting fields of every Client.
+type rateLimiter struct {
+	config RateLimitConfig
+	users  map[string]*rateState // Map userID to their state
+	mu     sync.Mutex
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	return &rateLimiter{config: config, users: make(map[string]*rateState)}
+}
+
+// check takes a token for a frame of msgType from the client's connection
+// bucket and from the bucket for the type. userID is empty before the client
+// authenticates.
+func (l *rateLimiter) check(client *Client, userID, msgType string, now time.Time) rateVerdict {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	state := client.rate
+	if userID != "" {
+		if state = l.users[userID]; state == nil {
+			state = &rateState{}
+			l.users[userID] = state
+		}
+	} else if state == nil {
+		state = &rateState{}
+		client.rate = state
+	}
+
+	if now.Before(state.mutedUntil) {
+		return state.violate(l.config, now)
+	}
+	if !client.frames.allow(l.config.Connection, now) || !state.allow(msgType, l.rate(msgType), now) {
+		return state.violate(l.config, now)
+	}
+	return rateVerdict{}
+}
+
+func (l *rateLimiter) rate(msgType string) Rate {
+	if rate, ok := l.config.Types[msgType]; ok {
+		return rate
+	}
+	return l.config.Default
+}
+
+// forget drops the state of a user who is gone.
+func (l *rateLimiter) forget(userID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.users, userID)
+}
+
+func (b *tokenBucket) allow(rate Rate, now time.Time) bool {
+	if rate.PerSecond <= 0 {
+		return true
+	}
+	return b.take(rate.PerSecond, float64(rate.Burst), now)
+}
+
+func (s *rateState) allow(msgType string, rate Rate, now time.Time) bool {
+	if rate.PerSecond <= 0 {
+		return true
+	}
+	if s.buckets == nil {
+		s.buckets = make(map[string]*tokenBucket)
+	}
+	bucket, ok := s.buckets[msgType]
+	if !ok {
+		bucket = &tokenBucket{}
+		s.buckets[msgType] = bucket
+	}
+	return bucket.allow(rate, now)
+}
+
+// violate records a rejected frame and decides how far to escalate.
+func (s *rateState) violate(config RateLimitConfig, now time.Time) rateVerdict {
+	cutoff := now.Add(-config.Window)
+	n := 0
+	for n < len(s.violations) && !s.violations[n].After(cutoff) {
+		n++
+	}
+	s.violations = append(s.violations[n:], now)
+	// Only the count matters once it is past every threshold, and it has to
+	// stay past them so that a step is not taken again.
+	if keep := max(config.WarnAfter, config.MuteAfter, config.DisconnectAfter) + 1; len(s.violations) > keep {
+		s.violations = s.violations[len(s.violations)-keep:]
+	}
+
+	v := rateVerdict{action: rateRejected, violations: len(s.violations), mutedUntil: s.mutedUntil}
+	switch {
+	case config.DisconnectAfter > 0 && v.violations >= config.DisconnectAfter:
+		v.action = rateDisconnected
+	case config.MuteAfter > 0 && v.violations == config.MuteAfter:
+		s.mutedUntil = now.Add(config.MuteFor)
+		v.action, v.mutedUntil = rateMuted, s.mutedUntil
+	case config.WarnAfter > 0 && v.violations == config.WarnAfter:
+		v.action = rateWarned
+	}
+	return v
+}
+
+// admit applies the rate limits to a frame before it is dispatched. A
+// rejected frame is answered with a rate_limited error, and repeated
+// violations escalate as configured.
+func (h *Hub) admit(req *Request) bool {
+	now := h.clock.Now()
+	v := h.limiter.check(req.Client, req.UserID, req.Type, now)
+	if v.action == rateAllowed {
+		return true
+	}
+	message := "too many requests"
+	if now.Before(v.mutedUntil) {
+		message = "muted for sending too many requests"
+	}
+	req.Reply(errorMessage(req.Type, &Error{Code: CodeRateLimited, Message: message}))
+
+	switch v.action {
+	case rateWarned:
+		req.Client.enqueue(OutgoingMessage{
+			Type:    TypeRateLimitWarning,
+			Payload: RateLimitWarningPayload{Violations: v.violations, MuteAfter: h.limiter.config.MuteAfter},
+		})
+	case rateMuted:
+		log.Printf("muting user %q for sending too many requests", req.UserID)
+		muted := OutgoingMessage{Type: TypeRateLimitMuted, Payload: RateLimitMutedPayload{Until: v.mutedUntil.UnixMilli()}}
+		for _, client := range h.connectionsOf(req) {
+			client.enqueue(muted)
+		}
+	case rateDisconnected:
+		log.Printf("disconnecting user %q for sending too many requests", req.UserID)
+		for _, client := range h.connectionsOf(req) {
+			client.disconnect(CloseRateLimited)
+		}
+	}
+	return false
+}
+
+// connectionsOf returns the connections that share the request's rate
+// limits: all of the user's, or just the one before it authenticates.
+func (h *Hub) connectionsOf(req *Request) []*Client {
+	if req.UserID == "" {
+		return []*Client{req.Client}
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.clients[req.UserID]))
+	for client := range h.clients[req.UserID] {
+		clients = append(clients, client)
+	}
+	return clients
+}
@@ -0,0 +1,86 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateStateViolate(t *testing.T) {
+	config := RateLimitConfig{
+		Window:          time.Minute,
+		WarnAfter:       1,
+		MuteAfter:       3,
+		MuteFor:         30 * time.Second,
+		DisconnectAfter: 5,
+	}
+	type violation struct {
+		after          time.Duration // Since the previous violation
+		wantAction     rateAction
+		wantViolations int
+	}
+	tests := []struct {
+		name       string
+		config     RateLimitConfig
+		violations []violation
+	}{
+		{
+			name:   "escalates through every step",
+			config: config,
+			violations: []violation{
+				{0, rateWarned, 1},
+				{time.Second, rateRejected, 2},
+				{time.Second, rateMuted, 3},
+				{time.Second, rateRejected, 4},
+				{time.Second, rateDisconnected, 5},
+				{time.Second, rateDisconnected, 6},
+				{time.Second, rateDisconnected, 6},
+			},
+		},
+		{
+			name:   "violations outside the window are forgotten",
+			config: config,
+			violations: []violation{
+				{0, rateWarned, 1},
+				{30 * time.Second, rateRejected, 2},
+				{31 * time.Second, rateRejected, 2},
+				{time.Minute, rateWarned, 1},
+			},
+		},
+		{
+			name:   "zero thresholds skip their step",
+			config: RateLimitConfig{Window: time.Minute, MuteAfter: 2},
+			violations: []violation{
+				{0, rateRejected, 1},
+				{time.Second, rateMuted, 2},
+				{time.Second, rateRejected, 3},
+				{time.Second, rateRejected, 3},
+			},
+		},
+		{
+			name:   "warns once",
+			config: RateLimitConfig{Window: time.Minute, WarnAfter: 1},
+			violations: []violation{
+				{0, rateWarned, 1},
+				{time.Second, rateRejected, 2},
+				{time.Second, rateRejected, 2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &rateState{}
+			now := time.Unix(1700000000, 0)
+			for i, v := range tt.violations {
+				now = now.Add(v.after)
+				got := state.violate(tt.config, now)
+				if got.action != v.wantAction || got.violations != v.wantViolations {
+					t.Fatalf("violation %d: got action %d with %d violations, want %d with %d",
+						i, got.action, got.violations, v.wantAction, v.wantViolations)
+				}
+				if v.wantAction == rateMuted && !got.mutedUntil.Equal(now.Add(tt.config.MuteFor)) {
+					t.Fatalf("violation %d: muted until %v, want %v", i, got.mutedUntil, now.Add(tt.config.MuteFor))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package websocket
+
+import (
+	"context"
+	"unicode/utf8"
+)
+
+const (
+	// maxReactionsPerMessage caps the distinct emoji on one message.
+	maxReactionsPerMessage = 20
+	// maxReactionsPerUser caps the distinct emoji one user puts on one
+	// message.
+	maxReactionsPerUser = 3
+	// maxEmojiBytes leaves room for skin tones and joined sequences.
+	maxEmojiBytes = 32
+)
+
+// reaction is one emoji on a message and who reacted with it.
+type reaction struct {
+	emoji   string
+	userIDs []string // In the order they reacted
+}
+
+// reactionsOf returns how many distinct emoji the user put on the message.
+func (e *envelope) reactionsOf(userID string) int {
+	n := 0
+	for _, r := range e.reactions {
+		for _, id := range r.userIDs {
+			if id == userID {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// addReaction records the user's reaction and reports whether it is new.
+func (e *envelope) addReaction(emoji, userID string) (bool, error) {
+	var found *reaction
+	for _, r := range e.reactions {
+		if r.emoji == emoji {
+			found = r
+			break
+		}
+	}
+	if found != nil {
+		for _, id := range found.userIDs {
+			if id == userID {
+				return false, nil
+			}
+		}
+	} else if len(e.reactions) >= maxReactionsPerMessage {
+		return false, &Error{Code: CodeLimitReached, Message: "the message has too many different reactions"}
+	}
+	if e.reactionsOf(userID) >= maxReactionsPerUser {
+		return false, &Error{Code: CodeLimitReached, Message: "too many reactions on this message"}
+	}
+
+	if found == nil {
+		found = &reaction{emoji: emoji}
+		e.reactions = append(e.reactions, found)
+	}
+	found.userIDs = append(found.userIDs, userID)
+	return true, nil
+}
+
+// removeReaction drops the user's reaction and reports whether there was one.
+func (e *envelope) removeReaction(emoji, userID string) bool {
+	for i, r := range e.reactions {
+		if r.emoji != emoji {
+			continue
+		}
+		for j, id := range r.userIDs {
+			if id != userID {
+				continue
+			}
+			r.userIDs = append(r.userIDs[:j], r.userIDs[j+1:]...)
+			if len(r.userIDs) == 0 {
+				e.reactions = append(e.reactions[:i], e.reactions[i+1:]...)
+			}
+			return true
+		}
+		return false
+	}
+	return false
+}
+
+// tallies returns the message's reactions in the order they were first used.
+func (e *envelope) tallies() []ReactionTally {
+	if len(e.reactions) == 0 {
+		return nil
+	}
+	tallies := make([]ReactionTally, len(e.reactions))
+	for i, r := range e.reactions {
+		tallies[i] = ReactionTally{
+			Emoji:   r.emoji,
+			Count:   len(r.userIDs),
+			UserIDs: append([]string(nil), r.userIDs...),
+		}
+	}
+	return tallies
+}
+
+// reactionHandler returns a handler that adds or removes a reaction. A
+// change is broadcast to the group as reactions_updated with the message's
+// full tally; repeating an add or removing a missing reaction only acks.
+func (h *Hub) reactionHandler(add bool) HandlerFunc {
+	return func(ctx context.Context, req *Request) error {
+		body := req.Body.(*ReactionPayload)
+		if body.GroupID == "" || body.MessageID == "" || body.Emoji == "" {
+			return invalidPayload("groupId, messageId and emoji are required")
+		}
+		if len(body.Emoji) > maxEmojiBytes || !utf8.ValidString(body.Emoji) {
+			return invalidPayload("emoji must be a short UTF-8 string")
+		}
+		if !h.isSubscribed(body.GroupID, req.Client) {
+			return errNotSubscribed
+		}
+
+		gl := h.groupLog(body.GroupID)
+		gl.mu.Lock()
+		defer gl.mu.Unlock()
+		now := h.clock.Now()
+		gl.history.trim(h.history, now)
+		e := gl.history.find(body.MessageID)
+		if e == nil || e.deleted {
+			return errMessageNotFound
+		}
+
+		var changed bool
+		if add {
+			var err error
+			if changed, err = e.addReaction(body.Emoji, req.UserID); err != nil {
+				return err
+			}
+		} else {
+			changed = e.removeReaction(body.Emoji, req.UserID)
+		}
+		if !changed {
+			req.Ack()
+			return nil
+		}
+
+		frame := gl.stamp(OutgoingMessage{
+			Type: TypeReactionsUpdated,
+			Payload: ReactionsUpdatedPayload{
+				GroupID:    body.GroupID,
+				MessageID:  body.MessageID,
+				MessageSeq: e.seq,
+				Reactions:  e.tallies(),
+			},
+		}, h.history, now)
+		h.mu.RLock()
+		h.fanOutLocked(body.GroupID, frame, func(*Client) bool { return true })
+		h.mu.RUnlock()
+		req.AckWith(AckPayload{Seq: frame.msg.Seq})
+		return nil
+	}
+}
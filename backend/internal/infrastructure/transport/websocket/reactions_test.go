@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// describeTallies writes tallies as "emoji=name,name" entries, so that
+// failures read in user names rather than IDs.
+func describeTallies(tallies []ReactionTally, names map[string]string) string {
+	entries := make([]string, len(tallies))
+	for i, tally := range tallies {
+		users := make([]string, len(tally.UserIDs))
+		for j, id := range tally.UserIDs {
+			users[j] = names[id]
+		}
+		if tally.Count != len(users) {
+			users = append(users, fmt.Sprintf("count %d", tally.Count))
+		}
+		entries[i] = tally.Emoji + "=" + strings.Join(users, ",")
+	}
+	return strings.Join(entries, " ")
+}
+
+// TestReactionTallies checks that every change to a message's reactions is
+// broadcast with the full tally, and that late joiners see it in history.
+func TestReactionTallies(t *testing.T) {
+	srv := newTestServer(t)
+	alice, bob, carol := srv.connect("alice"), srv.connect("bob"), srv.connect("carol")
+	names := map[string]string{alice.userID: "alice", bob.userID: "bob"}
+	groupID := alice.createGroup("group")
+	bob.join(groupID)
+	carol.join(groupID)
+	msg := alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("hi")})
+
+	steps := []struct {
+		c     *testConn
+		add   bool
+		emoji string
+		want  string // Tally carol is sent next, if the step changes it
+	}{
+		{c: bob, add: true, emoji: "👍", want: "👍=bob"},
+		{c: alice, add: true, emoji: "👍", want: "👍=bob,alice"},
+		{c: alice, add: true, emoji: "❤️", want: "👍=bob,alice ❤️=alice"},
+		{c: alice, add: true, emoji: "👍"},
+		{c: bob, emoji: "❤️"},
+		{c: bob, emoji: "👍", want: "👍=alice ❤️=alice"},
+		{c: alice, emoji: "👍", want: "❤️=alice"},
+	}
+	for i, s := range steps {
+		msgType := TypeRemoveReaction
+		if s.add {
+			msgType = TypeAddReaction
+		}
+		s.c.send(msgType, ReactionPayload{GroupID: groupID, MessageID: msg.MessageID, Emoji: s.emoji})
+		s.c.expect(TypeAck)
+		if s.want == "" {
+			continue
+		}
+		var update ReactionsUpdatedPayload
+		carol.expect(TypeReactionsUpdated).decode(carol, &update)
+		if update.MessageID != msg.MessageID || update.MessageSeq != msg.Seq {
+			t.Fatalf("step %d: update for %s at %d, want %s at %d", i, update.MessageID, update.MessageSeq, msg.MessageID, msg.Seq)
+		}
+		if got := describeTallies(update.Reactions, names); got != s.want {
+			t.Fatalf("step %d: got %q, want %q", i, got, s.want)
+		}
+	}
+
+	dave := srv.connect("dave")
+	dave.join(groupID)
+	dave.send(TypeFetchHistory, FetchHistoryPayload{GroupID: groupID})
+	var history HistoryPayload
+	dave.expect(TypeHistory).decode(dave, &history)
+	if len(history.Messages) != 1 {
+		t.Fatalf("history has %d messages, want 1", len(history.Messages))
+	}
+	if got := describeTallies(history.Messages[0].Reactions, names); got != "❤️=alice" {
+		t.Fatalf("history shows %q, want %q", got, "❤️=alice")
+	}
+}
+
+func TestReactionRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		users    int // Who react before the rejected one, each with up to maxReactionsPerUser emoji
+		emoji    string
+		unknown  bool // React to a message that does not exist
+		wantCode string
+	}{
+		{name: "too many from one user", users: 1, emoji: "x3", wantCode: CodeLimitReached},
+		{name: "too many on one message", users: 7, emoji: "x20", wantCode: CodeLimitReached},
+		{name: "emoji too long", emoji: strings.Repeat("x", maxEmojiBytes+1), wantCode: CodeInvalidPayload},
+		{name: "unknown message", emoji: "👍", unknown: true, wantCode: CodeMessageNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			alice := srv.connect("alice")
+			groupID := alice.createGroup("group")
+			msg := alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("hi")})
+			if tt.unknown {
+				msg.MessageID = "missing"
+			}
+
+			// The reactions are numbered emoji, filled up to the limits.
+			c, n := alice, 0
+			for u := 0; u < tt.users; u++ {
+				if u > 0 {
+					c = srv.connect(fmt.Sprintf("user%d", u))
+					c.join(groupID)
+				}
+				for i := 0; i < maxReactionsPerUser && n < maxReactionsPerMessage; i, n = i+1, n+1 {
+					c.send(TypeAddReaction, ReactionPayload{GroupID: groupID, MessageID: msg.MessageID, Emoji: fmt.Sprintf("x%d", n)})
+					c.expect(TypeAck)
+				}
+			}
+			c.send(TypeAddReaction, ReactionPayload{GroupID: groupID, MessageID: msg.MessageID, Emoji: tt.emoji})
+			if got := c.expectError(); got.Code != tt.wantCode {
+				t.Fatalf("got %s (%s), want %s", got.Code, got.Message, tt.wantCode)
+			}
+		})
+	}
+}
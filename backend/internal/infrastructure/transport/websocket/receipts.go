@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+const (
+	// maxTrackedReceipts is how many recent messages per group have their
+	// receipts counted. Receipts for older messages are accepted but no
+	// longer reported to the sender.
+	maxTrackedReceipts = 256
+	// receiptFlushInterval batches receipt updates to each sender.
+	receiptFlushInterval = time.Second
+)
+
+// Receipt kinds. A read receipt implies delivery.
+const (
+	receiptDelivered = iota
+	receiptRead
+)
+
+// receiptTracker aggregates the receipts of one group. Receipts are
+// cumulative, so each member only needs a watermark per kind, and counts are
+// kept for a bounded window of recent messages. Memory stays proportional to
+// the member count plus the window, however many receipts arrive.
+type receiptTracker struct {
+	mu         sync.Mutex
+	watermarks map[string]*receiptMarks // Map userID to their highest receipts
+	messages   []*receiptCount          // Ordered by seq
+	timer      clock.Timer              // Pending flush, if any
+}
+
+type receiptMarks struct {
+	delivered uint64
+	read      uint64
+}
+
+type receiptCount struct {
+	seq       uint64
+	senderID  string
+	total     int // Recipients who share receipts
+	delivered int
+	read      int
+	changed   bool // Counts changed since the last flush
+}
+
+// track starts counting receipts for a message.
+func (t *receiptTracker) track(seq uint64, senderID string, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := sort.Search(len(t.messages), func(i int) bool { return t.messages[i].seq > seq })
+	t.messages = append(t.messages, nil)
+	copy(t.messages[i+1:], t.messages[i:])
+	t.messages[i] = &receiptCount{seq: seq, senderID: senderID, total: total}
+	if len(t.messages) > maxTrackedReceipts {
+		t.messages[0] = nil
+		t.messages = t.messages[1:]
+	}
+}
+
+// join sets the watermarks of a new member to the group's latest message, so
+// their receipts only count for messages they were sent. A member who is
+// still tracked from an earlier membership starts over the same way.
+func (t *receiptTracker) join(userID string, lastSeq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.watermarks == nil {
+		t.watermarks = make(map[string]*receiptMarks)
+	}
+	t.watermarks[userID] = &receiptMarks{delivered: lastSeq, read: lastSeq}
+}
+
+// forget drops the watermarks of a member who left.
+func (t *receiptTracker) forget(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.watermarks, userID)
+}
+
+// record applies a receipt for every message up to seq. It reports whether
+// any count changed.
+func (t *receiptTracker) record(userID string, kind int, seq uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.watermarks == nil {
+		t.watermarks = make(map[string]*receiptMarks)
+	}
+	marks := t.watermarks[userID]
+	if marks == nil {
+		marks = &receiptMarks{}
+		t.watermarks[userID] = marks
+	}
+
+	changed := false
+	if seq > marks.delivered {
+		changed = t.countLocked(userID, marks.delivered, seq, func(c *receiptCount) { c.delivered++ })
+		marks.delivered = seq
+	}
+	if kind == receiptRead && seq > marks.read {
+		changed = t.countLocked(userID, marks.read, seq, func(c *receiptCount) { c.read++ }) || changed
+		marks.read = seq
+	}
+	return changed
+}
+
+// countLocked applies inc to the tracked messages with from < seq <= to that
+// were sent by someone other than userID.
+func (t *receiptTracker) countLocked(userID string, from, to uint64, inc func(*receiptCount)) bool {
+	changed := false
+	i := sort.Search(len(t.messages), func(i int) bool { return t.messages[i].seq > from })
+	for ; i < len(t.messages) && t.messages[i].seq <= to; i++ {
+		c := t.messages[i]
+		if c.senderID == userID {
+			continue
+		}
+		inc(c)
+		c.changed = true
+		changed = true
+	}
+	return changed
+}
+
+// takeChanged returns the counts that changed since the last call, grouped
+// by sender.
+func (t *receiptTracker) takeChanged() map[string][]ReceiptCount {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timer = nil
+	changed := make(map[string][]ReceiptCount)
+	for _, c := range t.messages {
+		if !c.changed {
+			continue
+		}
+		c.changed = false
+		changed[c.senderID] = append(changed[c.senderID], ReceiptCount{
+			Seq:       c.seq,
+			Delivered: c.delivered,
+			Read:      c.read,
+			Total:     c.total,
+		})
+	}
+	return changed
+}
+
+// receiptsEnabled reports whether the user takes part in receipts.
+func (h *Hub) receiptsEnabled(ctx context.Context, userID string) bool {
+	user, err := h.chatService.GetUser(ctx, userID)
+	return err == nil && !user.GetPrivacy().DisableReceipts
+}
+
+// receiptTotal counts the members other than the sender who take part in
+// receipts, which is what a message's counts are out of.
+func (h *Hub) receiptTotal(ctx context.Context, groupID, senderID string) int {
+	group, err := h.chatService.GetGroup(ctx, groupID)
+	if err != nil {
+		return 0
+	}
+	total := 0
+	for _, member := range group.GetMembers() {
+		if member.ID != senderID && !member.GetPrivacy().DisableReceipts {
+			total++
+		}
+	}
+	return total
+}
+
+// receiptHandler returns a handler for delivered or read receipts. A receipt
+// covers every message of the group up to its sequence number.
+func (h *Hub) receiptHandler(kind int) HandlerFunc {
+	return func(ctx context.Context, req *Request) error {
+		body := req.Body.(*ReceiptPayload)
+		if body.GroupID == "" || body.Seq == 0 {
+			return invalidPayload("groupId and seq are required")
+		}
+		if !h.isSubscribed(body.GroupID, req.Client) {
+			return errNotSubscribed
+		}
+		if body.Seq > h.lastSeq(body.GroupID) {
+			return invalidPayload("seq is ahead of the group")
+		}
+
+		if h.receiptsEnabled(ctx, req.UserID) {
+			tracker := &h.groupLog(body.GroupID).receipts
+			if tracker.record(req.UserID, kind, body.Seq) {
+				h.scheduleReceiptFlush(body.GroupID, tracker)
+			}
+		}
+		req.Ack()
+		return nil
+	}
+}
+
+// scheduleReceiptFlush makes sure the group's changed counts are sent soon.
+func (h *Hub) scheduleReceiptFlush(groupID string, tracker *receiptTracker) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.timer == nil {
+		tracker.timer = h.clock.AfterFunc(receiptFlushInterval, func() { h.flushReceipts(groupID, tracker) })
+	}
+}
+
+// flushReceipts sends every sender one receipts_updated frame covering all
+// of their messages whose counts changed.
+func (h *Hub) flushReceipts(groupID string, tracker *receiptTracker) {
+	ctx := context.Background()
+	for senderID, counts := range tracker.takeChanged() {
+		if !h.receiptsEnabled(ctx, senderID) {
+			continue
+		}
+		h.sendToUser(senderID, OutgoingMessage{
+			Type:    TypeReceiptsUpdated,
+			Payload: ReceiptsUpdatedPayload{GroupID: groupID, Messages: counts},
+		}, nil)
+	}
+}
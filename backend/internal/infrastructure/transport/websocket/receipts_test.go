@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestReceiptTrackerRecord(t *testing.T) {
+	type receipt struct {
+		userID      string
+		kind        int
+		seq         uint64
+		wantChanged bool
+	}
+	type counts struct{ delivered, read int }
+	tests := []struct {
+		name     string
+		joined   map[string]uint64 // Map userID to the seq they joined at
+		receipts []receipt
+		want     []counts // For seqs 1..4, all sent by alice
+	}{
+		{
+			name:     "delivered up to a seq",
+			receipts: []receipt{{"bob", receiptDelivered, 2, true}},
+			want:     []counts{{1, 0}, {1, 0}, {0, 0}, {0, 0}},
+		},
+		{
+			name:     "read implies delivered",
+			receipts: []receipt{{"bob", receiptRead, 3, true}},
+			want:     []counts{{1, 1}, {1, 1}, {1, 1}, {0, 0}},
+		},
+		{
+			name: "receipts are cumulative",
+			receipts: []receipt{
+				{"bob", receiptDelivered, 2, true},
+				{"bob", receiptDelivered, 4, true},
+				{"bob", receiptRead, 1, true},
+			},
+			want: []counts{{1, 1}, {1, 0}, {1, 0}, {1, 0}},
+		},
+		{
+			name: "repeated and older receipts change nothing",
+			receipts: []receipt{
+				{"bob", receiptRead, 3, true},
+				{"bob", receiptRead, 3, false},
+				{"bob", receiptDelivered, 2, false},
+			},
+			want: []counts{{1, 1}, {1, 1}, {1, 1}, {0, 0}},
+		},
+		{
+			name:     "own messages are not counted",
+			receipts: []receipt{{"alice", receiptRead, 4, false}},
+			want:     []counts{{0, 0}, {0, 0}, {0, 0}, {0, 0}},
+		},
+		{
+			name:     "members count from when they joined",
+			joined:   map[string]uint64{"carol": 2},
+			receipts: []receipt{{"bob", receiptRead, 4, true}, {"carol", receiptRead, 4, true}},
+			want:     []counts{{1, 1}, {1, 1}, {2, 2}, {2, 2}},
+		},
+		{
+			name:     "nothing new since joining",
+			joined:   map[string]uint64{"carol": 4},
+			receipts: []receipt{{"carol", receiptRead, 4, false}},
+			want:     []counts{{0, 0}, {0, 0}, {0, 0}, {0, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &receiptTracker{}
+			for seq := uint64(1); seq <= 4; seq++ {
+				tracker.track(seq, "alice", 2)
+			}
+			for userID, seq := range tt.joined {
+				tracker.join(userID, seq)
+			}
+			for i, r := range tt.receipts {
+				if changed := tracker.record(r.userID, r.kind, r.seq); changed != r.wantChanged {
+					t.Fatalf("receipt %d: changed = %v, want %v", i, changed, r.wantChanged)
+				}
+			}
+			got := make([]counts, len(tracker.messages))
+			for i, c := range tracker.messages {
+				got[i] = counts{c.delivered, c.read}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("counts %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReceiptTrackerRejoin checks that a member who leaves and joins again
+// only counts for the messages sent after they came back.
+func TestReceiptTrackerRejoin(t *testing.T) {
+	tracker := &receiptTracker{}
+	tracker.track(1, "alice", 1)
+	tracker.join("bob", 0)
+	tracker.record("bob", receiptRead, 1)
+	tracker.forget("bob")
+
+	tracker.track(2, "alice", 1)
+	tracker.track(3, "alice", 1)
+	tracker.join("bob", 2)
+	tracker.record("bob", receiptDelivered, 3)
+
+	var delivered []int
+	for _, c := range tracker.messages {
+		delivered = append(delivered, c.delivered)
+	}
+	if want := []int{1, 0, 1}; !slices.Equal(delivered, want) {
+		t.Fatalf("delivered counts %v, want %v", delivered, want)
+	}
+}
@@ -0,0 +1,301 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"chat-app/server/internal/application"
+	"chat-app/server/internal/domain"
+)
+
+// Error codes carried by error frames.
+const (
+	CodeUnknownType        = "unknown_type"
+	CodeUnauthenticated    = "unauthenticated"
+	CodeRateLimited        = "rate_limited"
+	CodeInvalidPayload     = "invalid_payload"
+	CodeRequestFailed      = "request_failed"
+	CodeHelloRequired      = "hello_required"
+	CodeUserNotFound       = "user_not_found"
+	CodeGroupNotFound      = "group_not_found"
+	CodeAlreadyExists      = "already_exists"
+	CodeNotMember          = "not_a_member"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeSessionExpired     = "session_expired"
+	CodeForbidden          = "forbidden"
+	CodeMessageNotFound    = "message_not_found"
+	CodeLimitReached       = "limit_reached"
+	CodeFrameTooLarge      = "frame_too_large"
+	CodeUploadExpired      = "upload_expired"
+)
+
+// Error is a handler error that is reported to the client with a
+// machine-readable code.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Request is a single incoming frame being dispatched to a handler.
+type Request struct {
+	Hub     *Hub
+	Client  *Client
+	ID      string // Client-supplied correlation ID, may be empty
+	UserID  string // Empty until the client has authenticated
+	Version int    // Negotiated protocol version, 0 before hello
+	Type    string
+	Payload RawPayload  // Raw payload in the connection's wire format
+	Body    interface{} // Payload decoded by the Decode middleware
+}
+
+// Reply queues a message for the client that sent the request, tagged with
+// the request's correlation ID.
+func (r *Request) Reply(msg OutgoingMessage) {
+	msg.ID = r.ID
+	r.Client.enqueue(msg)
+}
+
+// Ack confirms a frame that has no dedicated reply. It is a no-op when the
+// client did not supply a correlation ID, since the ack could not be matched.
+func (r *Request) Ack() {
+	r.AckWith(AckPayload{})
+}
+
+// AckWith is Ack with details about the outcome, such as the sequence number
+// a message was given. The acknowledged type is filled in.
+func (r *Request) AckWith(payload AckPayload) {
+	if r.ID == "" {
+		return
+	}
+	payload.Type = r.Type
+	r.Reply(OutgoingMessage{Type: TypeAck, Payload: payload})
+}
+
+// HandlerFunc handles a single incoming frame. A returned error is sent back
+// to the client as an error frame.
+type HandlerFunc func(ctx context.Context, req *Request) error
+
+// Middleware wraps a HandlerFunc with additional behaviour.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Registry maps message types to their handlers. A handler can be overridden
+// for a single protocol version when a payload shape changes.
+type Registry struct {
+	handlers   map[string]HandlerFunc
+	versioned  map[versionedType]HandlerFunc
+	middleware []Middleware
+	mu         sync.RWMutex
+}
+
+type versionedType struct {
+	version int
+	msgType string
+}
+
+// NewRegistry creates an empty handler registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		handlers:  make(map[string]HandlerFunc),
+		versioned: make(map[versionedType]HandlerFunc),
+	}
+}
+
+// Use appends middleware that wraps every handler registered afterwards.
+func (r *Registry) Use(middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Handle registers the handler for a message type, replacing any existing one.
+// Middleware is applied in order, so the first one listed runs first.
+func (r *Registry) Handle(msgType string, handler HandlerFunc, middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[msgType] = r.wrap(handler, middleware)
+}
+
+// HandleVersion registers a handler that is used instead of the default one
+// for clients that negotiated the given protocol version.
+func (r *Registry) HandleVersion(version int, msgType string, handler HandlerFunc, middleware ...Middleware) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.versioned[versionedType{version, msgType}] = r.wrap(handler, middleware)
+}
+
+func (r *Registry) wrap(handler HandlerFunc, middleware []Middleware) HandlerFunc {
+	all := append(append([]Middleware{}, r.middleware...), middleware...)
+	for i := len(all) - 1; i >= 0; i-- {
+		handler = all[i](handler)
+	}
+	return handler
+}
+
+// Dispatch runs the handler registered for the request's type and reports
+// any failure to the client as an error frame.
+func (r *Registry) Dispatch(ctx context.Context, req *Request) {
+	r.mu.RLock()
+	handler, ok := r.versioned[versionedType{req.Version, req.Type}]
+	if !ok {
+		handler, ok = r.handlers[req.Type]
+	}
+	r.mu.RUnlock()
+
+	var err error
+	if ok {
+		err = handler(ctx, req)
+	} else {
+		err = &Error{Code: CodeUnknownType, Message: fmt.Sprintf("unknown message type %q", req.Type)}
+	}
+	if err != nil {
+		req.Reply(errorMessage(req.Type, err))
+	}
+}
+
+func errorMessage(msgType string, err error) OutgoingMessage {
+	var handlerErr *Error
+	if !errors.As(err, &handlerErr) {
+		handlerErr = &Error{Code: errorCode(err), Message: err.Error()}
+	}
+	return OutgoingMessage{
+		Type: TypeError,
+		Payload: ErrorPayload{
+			Code:    handlerErr.Code,
+			Message: handlerErr.Message,
+			Type:    msgType,
+		},
+	}
+}
+
+// errorCode maps application and domain errors to error frame codes.
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, application.ErrUserNotFound):
+		return CodeUserNotFound
+	case errors.Is(err, application.ErrGroupNotFound):
+		return CodeGroupNotFound
+	case errors.Is(err, application.ErrAlreadyExists):
+		return CodeAlreadyExists
+	case errors.Is(err, domain.ErrMemberNotFound):
+		return CodeNotMember
+	case errors.Is(err, application.ErrDirectGroup):
+		return CodeForbidden
+	default:
+		return CodeRequestFailed
+	}
+}
+
+// RequireAuth rejects frames from clients that have not authenticated.
+func RequireAuth() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) error {
+			if req.UserID == "" {
+				return &Error{Code: CodeUnauthenticated, Message: "not authenticated"}
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// RateLimit allows each connection a burst of frames, refilled at perSecond.
+// Every RateLimit middleware keeps its own bucket per connection. It is meant
+// for handlers added with Hub.Handle; the built-in frame types are limited by
+// the hub's RateLimitConfig before they are dispatched.
+func RateLimit(perSecond float64, burst int) Middleware {
+	limit := &rateLimit{perSecond: perSecond, burst: float64(burst)}
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) error {
+			if !req.Client.allow(limit, req.Hub.clock.Now()) {
+				return &Error{Code: CodeRateLimited, Message: "too many requests"}
+			}
+			return next(ctx, req)
+		}
+	}
+}
+
+// Decode strictly decodes the payload into a new T with the connection's
+// codec and stores it in Request.Body. Unknown fields are rejected.
+func Decode[T any]() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) error {
+			body := new(T)
+			if len(req.Payload) > 0 {
+				if err := req.Client.codec.Unmarshal(req.Payload, body); err != nil {
+					return &Error{Code: CodeInvalidPayload, Message: err.Error()}
+				}
+			}
+			req.Body = body
+			return next(ctx, req)
+		}
+	}
+}
+
+// Logging logs every handled frame together with its outcome and duration.
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx context.Context, req *Request) error {
+			start := time.Now()
+			err := next(ctx, req)
+			if err != nil {
+				log.Printf("ws %s user=%q failed after %s: %v", req.Type, req.UserID, time.Since(start), err)
+			} else {
+				log.Printf("ws %s user=%q handled in %s", req.Type, req.UserID, time.Since(start))
+			}
+			return err
+		}
+	}
+}
+
+// rateLimit is the configuration of a single RateLimit middleware.
+type rateLimit struct {
+	perSecond float64
+	burst     float64
+}
+
+// tokenBucket is the per-connection state for a rateLimit.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket for the time since it was last used and takes a
+// token if there is one. A bucket that has never been used starts full.
+func (b *tokenBucket) take(perSecond, burst float64, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * perSecond
+	}
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// allow takes a token from the connection's bucket for the limit.
+func (c *Client) allow(limit *rateLimit, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buckets == nil {
+		c.buckets = make(map[*rateLimit]*tokenBucket)
+	}
+	bucket, ok := c.buckets[limit]
+	if !ok {
+		bucket = &tokenBucket{}
+		c.buckets[limit] = bucket
+	}
+	return bucket.take(limit.perSecond, limit.burst, now)
+}
@@ -0,0 +1,164 @@
+package websocket
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"time"
+)
+
+// ResumeConfig configures session resumption. When an authenticated
+// connection drops, its session is detached instead of torn down: it keeps
+// its group subscriptions for Grace, and frames sent to it meanwhile are kept
+// in a buffer of up to BufferSize frames. A new connection that presents the
+// session's resume token takes the session over and receives the missed
+// frames in order.
+type ResumeConfig struct {
+	Grace      time.Duration // Zero disables resumption
+	BufferSize int           // Frames kept per detached session; the oldest go first
+}
+
+// DefaultResumeConfig keeps sessions long enough for a phone to switch
+// networks. The buffer stays below the default send buffer so a full replay
+// fits into a fresh connection's queue.
+var DefaultResumeConfig = ResumeConfig{Grace: 2 * time.Minute, BufferSize: 128}
+
+// WithResume configures session resumption.
+func WithResume(config ResumeConfig) Option {
+	return func(h *Hub) { h.resume = config }
+}
+
+// newResumeToken returns an unguessable token that identifies one session.
+func newResumeToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// startSession issues a resume token for an authenticated client. It returns
+// an empty token when resumption is disabled.
+func (h *Hub) startSession(client *Client) (string, error) {
+	if h.resume.Grace <= 0 {
+		return "", nil
+	}
+	token, err := newResumeToken()
+	if err != nil {
+		return "", fmt.Errorf("could not issue resume token: %w", err)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client.resumeToken = token
+	h.sessions[token] = client
+	return token, nil
+}
+
+// detachSession keeps the session of a dropped connection alive for the
+// grace period. It reports false when the client has no resumable session,
+// in which case the caller tears it down as usual.
+func (h *Hub) detachSession(client *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	token := client.resumeToken
+	if token == "" || h.sessions[token] != client {
+		return false
+	}
+	if !client.detach(h.resume.BufferSize) {
+		// The client was disconnected on purpose, for example as a slow
+		// consumer, so its session must not survive.
+		delete(h.sessions, token)
+		return false
+	}
+	client.expiry = h.clock.AfterFunc(h.resume.Grace, func() { h.expireSession(client) })
+	return true
+}
+
+// expireSession tears down a detached session that was not resumed in time.
+func (h *Hub) expireSession(client *Client) {
+	h.mu.Lock()
+	current := h.sessions[client.resumeToken] == client
+	if current {
+		delete(h.sessions, client.resumeToken)
+	}
+	h.mu.Unlock()
+	if current {
+		log.Printf("Session of user %s expired", client.UserID)
+		h.teardown(client)
+	}
+}
+
+// handleResume attaches the connection to an earlier session of the same
+// user in place of authenticating. The new connection inherits the group
+// subscriptions without rejoining, gets a fresh resume token, and then
+// receives the frames it missed, in order, ahead of any new traffic.
+func (h *Hub) handleResume(ctx context.Context, req *Request) error {
+	if req.UserID != "" {
+		return &Error{Code: CodeRequestFailed, Message: "already authenticated"}
+	}
+	body := req.Body.(*ResumePayload)
+	if body.ResumeToken == "" {
+		return invalidPayload("resumeToken is required")
+	}
+	if h.resume.Grace <= 0 {
+		return &Error{Code: CodeSessionExpired, Message: "session resumption is disabled"}
+	}
+	newToken, err := newResumeToken()
+	if err != nil {
+		return fmt.Errorf("could not issue resume token: %w", err)
+	}
+
+	// Everything below happens under the hub lock, so no broadcast can slip
+	// in between the replayed frames.
+	h.mu.Lock()
+	old := h.sessions[body.ResumeToken]
+	if old == nil {
+		h.mu.Unlock()
+		return &Error{Code: CodeSessionExpired, Message: "session cannot be resumed"}
+	}
+	delete(h.sessions, body.ResumeToken)
+	if old.expiry != nil {
+		old.expiry.Stop()
+	}
+
+	// The old connection may not have noticed yet that it is dead. Detaching
+	// it stops its write pump and saves whatever it had not written.
+	old.detach(h.resume.BufferSize)
+	missed, dropped := old.takeMissed()
+
+	userID := old.UserID
+	groupIDs := h.subscriptionsLocked(old)
+	for _, groupID := range groupIDs {
+		delete(h.groups[groupID], old)
+		h.groups[groupID][req.Client] = true
+	}
+	delete(h.clients[userID], old)
+	h.clients[userID][req.Client] = true
+	req.Client.UserID = userID
+	req.Client.resumeToken = newToken
+	h.sessions[newToken] = req.Client
+
+	req.Reply(OutgoingMessage{
+		Type: TypeResumed,
+		Payload: ResumedPayload{
+			ResumeToken: newToken,
+			UserID:      userID,
+			Groups:      groupIDs,
+			Replayed:    len(missed),
+			Dropped:     dropped,
+		},
+	})
+	for _, msg := range missed {
+		req.Client.enqueueOutbound(msg)
+	}
+	h.mu.Unlock()
+
+	// Closing the old connection makes its read pump unregister it, which
+	// is a no-op now that it owns nothing.
+	old.conn.Close()
+	h.touch(userID)
+	log.Printf("User %s resumed session, replayed %d frames", userID, len(missed))
+	return nil
+}
@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+// drop closes the connection and waits until the hub has detached its
+// session.
+func (s *testServer) drop(c *testConn) {
+	s.t.Helper()
+	s.hub.mu.RLock()
+	client := s.hub.sessions[c.resumeToken]
+	s.hub.mu.RUnlock()
+	if client == nil {
+		s.t.Fatalf("user %s has no session", c.userID)
+	}
+	c.conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		client.mu.Lock()
+		detached := client.detached
+		client.mu.Unlock()
+		if detached {
+			return
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("session of user %s was not detached", c.userID)
+		}
+	}
+}
+
+func TestResumeReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      ResumeConfig
+		sent        int
+		wait        time.Duration // Before resuming
+		wantExpired bool
+		wantDropped int
+	}{
+		{
+			name:   "within the grace period",
+			config: ResumeConfig{Grace: time.Minute, BufferSize: 8},
+			sent:   3,
+			wait:   time.Minute - time.Millisecond,
+		},
+		{
+			name:        "buffer overflow drops the oldest",
+			config:      ResumeConfig{Grace: time.Minute, BufferSize: 2},
+			sent:        3,
+			wantDropped: 1,
+		},
+		{
+			name:        "expired",
+			config:      ResumeConfig{Grace: time.Minute, BufferSize: 8},
+			sent:        3,
+			wait:        time.Minute,
+			wantExpired: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, WithResume(tt.config))
+			alice, bob := srv.connect("alice"), srv.connect("bob")
+			groupID := alice.createGroup("group")
+			bob.join(groupID)
+			srv.drop(bob)
+
+			var seqs []uint64
+			for i := 0; i < tt.sent; i++ {
+				seqs = append(seqs, alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("hi")}).Seq)
+			}
+			srv.clock.Advance(tt.wait)
+
+			c := srv.dial()
+			c.send(TypeResume, ResumePayload{ResumeToken: bob.resumeToken})
+			if tt.wantExpired {
+				if got := c.expectError(); got.Code != CodeSessionExpired {
+					t.Fatalf("got %s, want %s", got.Code, CodeSessionExpired)
+				}
+				return
+			}
+			var resumed ResumedPayload
+			c.expect(TypeResumed).decode(c, &resumed)
+			if resumed.UserID != bob.userID || len(resumed.Groups) != 1 || resumed.Groups[0] != groupID {
+				t.Fatalf("resumed %s in %v, want %s in [%s]", resumed.UserID, resumed.Groups, bob.userID, groupID)
+			}
+			wantReplayed := tt.sent - tt.wantDropped
+			if resumed.Replayed != wantReplayed || resumed.Dropped != tt.wantDropped {
+				t.Fatalf("replayed %d and dropped %d, want %d and %d", resumed.Replayed, resumed.Dropped, wantReplayed, tt.wantDropped)
+			}
+			for _, want := range seqs[tt.wantDropped:] {
+				if frame := c.nextEvent(); frame.Type != TypeNewMessage || frame.Seq != want {
+					t.Fatalf("got %s %d, want new_message %d", frame.Type, frame.Seq, want)
+				}
+			}
+
+			// The session stays subscribed, and its old token is spent.
+			live := alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("live")})
+			if frame := c.nextEvent(); frame.Type != TypeNewMessage || frame.Seq != live.Seq {
+				t.Fatalf("got %s %d, want new_message %d", frame.Type, frame.Seq, live.Seq)
+			}
+			again := srv.dial()
+			again.send(TypeResume, ResumePayload{ResumeToken: bob.resumeToken})
+			if got := again.expectError(); got.Code != CodeSessionExpired {
+				t.Fatalf("reusing the token got %s, want %s", got.Code, CodeSessionExpired)
+			}
+		})
+	}
+}
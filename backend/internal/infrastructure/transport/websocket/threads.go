@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"context"
+)
+
+// thread tracks the replies to a message. Threads are one level deep: a
+// reply to a reply joins the thread of the message that started it.
+type thread struct {
+	replies      int
+	lastReplyAt  int64    // Unix milliseconds
+	participants []string // Who started the thread or replied, in order of first post
+}
+
+// threadRoot returns the message that starts the thread messageID belongs
+// to, or nil if it is no longer in history.
+func (m *messageHistory) threadRoot(messageID string) *envelope {
+	e := m.find(messageID)
+	if e != nil && e.parentID != "" {
+		e = m.find(e.parentID)
+	}
+	return e
+}
+
+// reply counts a reply to the thread this message starts. It returns the
+// participants who were in the thread before, other than the sender.
+func (e *envelope) reply(senderID string, at int64) []string {
+	if e.thread == nil {
+		e.thread = &thread{participants: []string{e.senderID}}
+	}
+	t := e.thread
+	t.replies++
+	t.lastReplyAt = at
+
+	var others []string
+	joined := false
+	for _, id := range t.participants {
+		if id == senderID {
+			joined = true
+		} else {
+			others = append(others, id)
+		}
+	}
+	if !joined {
+		t.participants = append(t.participants, senderID)
+	}
+	return others
+}
+
+// threadNotice is a thread_reply that waits for the locks it was prepared
+// under to be released before it is sent.
+type threadNotice struct {
+	groupID    string
+	frame      *sharedFrame
+	recipients []string
+}
+
+// newThreadNotice prepares the thread_reply for a reply to root's thread.
+// The participants the reply mentions are left out, since they hear about
+// it through mentioned instead. The caller holds the group log's lock.
+func newThreadNotice(root *envelope, reply NewMessagePayload, participants []string) *threadNotice {
+	if len(participants) == 0 {
+		return nil
+	}
+	mentioned := make(map[string]bool, len(reply.Mentions))
+	for _, id := range reply.Mentions {
+		mentioned[id] = true
+	}
+	n := &threadNotice{groupID: reply.GroupID}
+	for _, userID := range participants {
+		if !mentioned[userID] {
+			n.recipients = append(n.recipients, userID)
+		}
+	}
+	n.frame = newSharedFrame(OutgoingMessage{
+		Type: TypeThreadReply,
+		Payload: ThreadReplyPayload{
+			GroupID:     reply.GroupID,
+			ParentID:    root.messageID,
+			MessageID:   reply.MessageID,
+			SenderID:    reply.SenderID,
+			ReplyCount:  root.thread.replies,
+			LastReplyAt: root.thread.lastReplyAt,
+		},
+	})
+	return n
+}
+
+// notifyThread sends a prepared thread_reply to the connections of each
+// recipient that are still subscribed to the group, skipping those who muted
+// it. The users are looked up before the hub lock is taken.
+func (h *Hub) notifyThread(n *threadNotice) {
+	ctx := context.Background()
+	recipients := n.recipients[:0]
+	for _, userID := range n.recipients {
+		if user, err := h.chatService.GetUser(ctx, userID); err == nil && !user.IsMuted(n.groupID) {
+			recipients = append(recipients, userID)
+		}
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	members := h.groups[n.groupID]
+	for _, userID := range recipients {
+		for client := range h.clients[userID] {
+			if members[client] {
+				client.enqueueShared(n.frame)
+			}
+		}
+	}
+}
+
+// handleFetchThread pages back through the replies to a message. Replies are
+// served from the group's history, so they age out with the rest of it.
+func (h *Hub) handleFetchThread(ctx context.Context, req *Request) error {
+	body := req.Body.(*FetchThreadPayload)
+	if body.GroupID == "" || body.ParentID == "" {
+		return invalidPayload("groupId and parentId are required")
+	}
+	limit := body.Limit
+	if limit <= 0 {
+		limit = defaultHistoryPage
+	}
+	if limit > maxHistoryPage {
+		limit = maxHistoryPage
+	}
+	if !h.isSubscribed(body.GroupID, req.Client) {
+		return errNotSubscribed
+	}
+
+	gl := h.groupLog(body.GroupID)
+	gl.mu.Lock()
+	gl.history.trim(h.history, h.clock.Now())
+	root := gl.history.threadRoot(body.ParentID)
+	if root == nil {
+		gl.mu.Unlock()
+		return errMessageNotFound
+	}
+	page, more := gl.history.page(body.BeforeSeq, limit, func(e *envelope) bool { return e.parentID == root.messageID })
+	messages := make([]HistoryMessage, len(page))
+	for i, e := range page {
+		messages[i] = e.view()
+	}
+	payload := ThreadPayload{
+		GroupID:  body.GroupID,
+		ParentID: root.messageID,
+		Messages: messages,
+		HasMore:  more,
+	}
+	if root.thread != nil {
+		payload.ReplyCount = root.thread.replies
+		payload.LastReplyAt = root.thread.lastReplyAt
+	}
+	gl.mu.Unlock()
+
+	req.Reply(OutgoingMessage{Type: TypeThread, Payload: payload})
+	return nil
+}
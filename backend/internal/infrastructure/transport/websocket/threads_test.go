@@ -0,0 +1,40 @@
+package websocket
+
+import (
+	"testing"
+)
+
+// TestThreadReplyNotifications checks that thread participants hear about
+// new replies unless they muted the group.
+func TestThreadReplyNotifications(t *testing.T) {
+	srv := newTestServer(t)
+	alice, bob, carol := srv.connect("alice"), srv.connect("bob"), srv.connect("carol")
+	groupID := alice.createGroup("group")
+	bob.join(groupID)
+	carol.join(groupID)
+
+	root := alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("root")})
+	reply := bob.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("reply"), ParentID: root.MessageID})
+	var notice ThreadReplyPayload
+	alice.expect(TypeThreadReply).decode(alice, &notice)
+	if notice.ParentID != root.MessageID || notice.MessageID != reply.MessageID || notice.ReplyCount != 1 {
+		t.Fatalf("got %+v, want reply %s to %s as the first reply", notice, reply.MessageID, root.MessageID)
+	}
+
+	alice.send(TypeMuteGroup, MuteGroupPayload{GroupID: groupID, Muted: true})
+	alice.expect(TypeAck)
+	muted := carol.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("muted"), ParentID: reply.MessageID})
+	bob.expect(TypeThreadReply).decode(bob, &notice)
+	if notice.ParentID != root.MessageID || notice.MessageID != muted.MessageID || notice.ReplyCount != 2 {
+		t.Fatalf("got %+v, want reply %s to %s as the second reply", notice, muted.MessageID, root.MessageID)
+	}
+
+	// The muted participant gets the reply and then the next message, with
+	// no thread_reply in between.
+	after := bob.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("after")})
+	for _, want := range []uint64{muted.Seq, after.Seq} {
+		if frame := alice.nextEvent(); frame.Type != TypeNewMessage || frame.Seq != want {
+			t.Fatalf("got %s %d, want new_message %d", frame.Type, frame.Seq, want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package websocket
+
+import (
+	"context"
+	"time"
+
+	"chat-app/server/internal/infrastructure/clock"
+)
+
+const (
+	// typingThrottle is the minimum time between two typing_started relays
+	// for the same user and group. Starts in between only keep the state alive.
+	typingThrottle = 3 * time.Second
+	// typingTimeout ends a typing state that received no start or stop.
+	typingTimeout = 6 * time.Second
+)
+
+// typingState tracks one user typing in one group. Starts only move
+// lastActive; the timer checks it when it fires, so a user who keeps typing
+// costs one timer per timeout rather than one per frame.
+type typingState struct {
+	relayedAt  time.Time
+	lastActive time.Time
+	timer      clock.Timer
+}
+
+// handleTypingStart tells the other online members that the user is typing.
+// Nothing is stored beyond the state needed for throttling and expiry, and
+// only starts that are relayed touch the hub's lock beyond a subscription
+// check.
+func (h *Hub) handleTypingStart(ctx context.Context, req *Request) error {
+	body := req.Body.(*TypingPayload)
+	if body.GroupID == "" {
+		return invalidPayload("groupId is required")
+	}
+	groupID, userID := body.GroupID, req.UserID
+	if !h.isSubscribed(groupID, req.Client) {
+		return errNotSubscribed
+	}
+	now := h.clock.Now()
+
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+	users := h.typing[groupID]
+	if users == nil {
+		users = make(map[string]*typingState)
+		h.typing[groupID] = users
+	}
+	state := users[userID]
+	if state == nil {
+		state = &typingState{}
+		users[userID] = state
+		h.armTypingLocked(groupID, userID, state, typingTimeout)
+	}
+	state.lastActive = now
+
+	if now.Sub(state.relayedAt) >= typingThrottle {
+		state.relayedAt = now
+		h.mu.RLock()
+		h.relayTypingLocked(groupID, userID, TypeTypingStarted)
+		h.mu.RUnlock()
+	}
+	req.Ack()
+	return nil
+}
+
+// handleTypingStop ends the user's typing state in a group, if any.
+func (h *Hub) handleTypingStop(ctx context.Context, req *Request) error {
+	body := req.Body.(*TypingPayload)
+	if body.GroupID == "" {
+		return invalidPayload("groupId is required")
+	}
+	h.stopTyping(body.GroupID, req.UserID)
+	req.Ack()
+	return nil
+}
+
+// armTypingLocked schedules the expiry check of a typing state. The caller
+// holds h.typingMu.
+func (h *Hub) armTypingLocked(groupID, userID string, state *typingState, d time.Duration) {
+	var timer clock.Timer
+	timer = h.clock.AfterFunc(d, func() { h.expireTyping(groupID, userID, timer) })
+	state.timer = timer
+}
+
+// expireTyping stops a typing state whose timer ran out, or checks again
+// later if the user was active in the meantime.
+func (h *Hub) expireTyping(groupID, userID string, timer clock.Timer) {
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+	state := h.typing[groupID][userID]
+	if state == nil || state.timer != timer {
+		return
+	}
+	if idle := h.clock.Now().Sub(state.lastActive); idle < typingTimeout {
+		h.armTypingLocked(groupID, userID, state, typingTimeout-idle)
+		return
+	}
+	h.stopTypingLocked(groupID, userID)
+}
+
+// stopTyping clears the user's typing state in a group and tells the other
+// members. The caller must not hold h.mu.
+func (h *Hub) stopTyping(groupID, userID string) {
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+	h.stopTypingLocked(groupID, userID)
+}
+
+// stopTypingLocked is stopTyping for a caller that holds h.typingMu.
+func (h *Hub) stopTypingLocked(groupID, userID string) {
+	state := h.typing[groupID][userID]
+	if state == nil {
+		return
+	}
+	state.timer.Stop()
+	delete(h.typing[groupID], userID)
+	if len(h.typing[groupID]) == 0 {
+		delete(h.typing, groupID)
+	}
+	h.mu.RLock()
+	h.relayTypingLocked(groupID, userID, TypeTypingStopped)
+	h.mu.RUnlock()
+}
+
+// forgetTyping drops the typing state of a deleted group without telling
+// anyone.
+func (h *Hub) forgetTyping(groupID string) {
+	h.typingMu.Lock()
+	defer h.typingMu.Unlock()
+	for _, state := range h.typing[groupID] {
+		state.timer.Stop()
+	}
+	delete(h.typing, groupID)
+}
+
+// relayTypingLocked sends a typing event to the group's connections other
+// than the user's own. It is transient, so detached sessions don't keep it
+// for replay. The caller holds h.mu.
+func (h *Hub) relayTypingLocked(groupID, userID, msgType string) {
+	frame := newSharedFrame(OutgoingMessage{
+		Type:    msgType,
+		Payload: TypingEventPayload{GroupID: groupID, UserID: userID},
+	})
+	for member := range h.groups[groupID] {
+		if member.UserID != userID {
+			member.enqueueTransient(frame)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTypingRelay(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		send    string // typing_start, typing_stop, or nothing
+		want    string // What the other member sees, if anything
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "start and stop",
+			steps: []step{
+				{send: TypeTypingStart, want: TypeTypingStarted},
+				{send: TypeTypingStop, want: TypeTypingStopped},
+				{send: TypeTypingStop},
+			},
+		},
+		{
+			name: "starts within the throttle are not relayed",
+			steps: []step{
+				{send: TypeTypingStart, want: TypeTypingStarted},
+				{advance: typingThrottle - time.Millisecond, send: TypeTypingStart},
+				{advance: time.Millisecond, send: TypeTypingStart, want: TypeTypingStarted},
+			},
+		},
+		{
+			name: "expires without a stop",
+			steps: []step{
+				{send: TypeTypingStart, want: TypeTypingStarted},
+				{advance: typingTimeout - time.Millisecond},
+				{advance: time.Millisecond, want: TypeTypingStopped},
+			},
+		},
+		{
+			name: "throttled starts keep it alive",
+			steps: []step{
+				{send: TypeTypingStart, want: TypeTypingStarted},
+				{advance: typingThrottle - time.Second, send: TypeTypingStart},
+				{advance: typingTimeout - typingThrottle + time.Second},
+				{advance: typingThrottle - time.Second, want: TypeTypingStopped},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			alice, bob := srv.connect("alice"), srv.connect("bob")
+			groupID := alice.createGroup("group")
+			bob.join(groupID)
+
+			for i, s := range tt.steps {
+				srv.clock.Advance(s.advance)
+				if s.send != "" {
+					alice.send(s.send, TypingPayload{GroupID: groupID})
+					alice.expect(TypeAck)
+				}
+				// A message marks the end of the step for bob.
+				marker := alice.sendMessage(SendMessagePayload{GroupID: groupID, Ciphertext: []byte("marker")})
+				if s.want != "" {
+					var event TypingEventPayload
+					frame := bob.nextEvent()
+					if frame.Type != s.want {
+						t.Fatalf("step %d: got %s, want %s", i, frame.Type, s.want)
+					}
+					frame.decode(bob, &event)
+					if event.GroupID != groupID || event.UserID != alice.userID {
+						t.Fatalf("step %d: got %+v, want alice in the group", i, event)
+					}
+				}
+				if frame := bob.nextEvent(); frame.Type != TypeNewMessage || frame.Seq != marker.Seq {
+					t.Fatalf("step %d: got %s, want only the marker message", i, frame.Type)
+				}
+			}
+		})
+	}
+}